@@ -3,6 +3,7 @@ package clicommon
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"syscall"
@@ -68,6 +69,14 @@ func CliQuestion(question string) string {
 	return answer
 }
 
+// CliQuestionLine prints a prompt to stdout and reads a whole line of input
+// from stdin, including any spaces, returning it without the line ending
+func CliQuestionLine(question string) (string, error) {
+	fmt.Printf("%s: ", question)
+
+	return readLine()
+}
+
 // CliQuestionHidden prints a prompt to stdout and reads a hidden line of input
 // from stdin. This is meant to be used for passwords where you don't want them
 // printed to the screen
@@ -455,3 +464,30 @@ func truncateChoice(s string) (string, error) {
 
 	return s, nil
 }
+
+// readLine reads stdin one byte at a time up to the next newline, so that no
+// input past the line is buffered away from later reads
+func readLine() (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+
+	for {
+		bytesRead, err := os.Stdin.Read(buf)
+		if bytesRead == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+
+			line = append(line, buf[0])
+			continue
+		}
+
+		if err == io.EOF && len(line) > 0 {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
@@ -0,0 +1,209 @@
+// Package clicommontest helps testing programs that use the privilege
+// escalation framework of clicommon, by running their sudo actions without
+// actually escalating and recording what was run
+package clicommontest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+
+	clicommon "github.com/madwire-media/go-cli-common"
+)
+
+// helperActionName is the action clicommon runs a sudo helper as
+const helperActionName = "helper"
+
+var (
+	// inProcessLock serializes in-process runs, since they swap os.Stdin
+	inProcessLock sync.Mutex
+
+	// mainCalled is set by Main, without which a subprocess would run the
+	// test binary's tests again instead of handling the sudo call
+	mainCalled bool
+)
+
+// Options configures a Harness
+type Options struct {
+	// Subprocess runs actions in a new process of the test binary, like a
+	// real escalator would but without any privileges, instead of inside of
+	// the test process. This is needed for sudo helpers and actions that
+	// exit. The test binary has to handle sudo calls by calling Main from
+	// TestMain, otherwise the subprocess would run every test again, so the
+	// harness refuses to run without it.
+	Subprocess bool
+}
+
+// Harness is an escalator for tests, which runs sudo actions without asking for
+// superuser permissions and records every action it ran. Actions run in the
+// test process by default, which swaps os.Stdin while they run, so tests using
+// a harness shouldn't run in parallel.
+type Harness struct {
+	options Options
+
+	mu       sync.Mutex
+	requests []clicommon.EscalationRequest
+	calls    []clicommon.SudoCallRecord
+}
+
+// Main handles sudo calls to the test binary before running its tests, for
+// harnesses with Options.Subprocess set. Call it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		clicommontest.Main(m)
+//	}
+func Main(m *testing.M) {
+	clicommon.TryHandleSudo()
+
+	mainCalled = true
+
+	os.Exit(m.Run())
+}
+
+// New creates a harness and makes every sudo call use it until the test ends.
+// Confirmation before escalating is turned off for the test as well.
+func New(t testing.TB, options Options) *Harness {
+	harness := &Harness{
+		options: options,
+	}
+
+	clicommon.SetEscalator(harness)
+	clicommon.SetSudoAssumeYes(true)
+
+	t.Cleanup(func() {
+		clicommon.SetEscalator(nil)
+		clicommon.SetSudoAssumeYes(false)
+	})
+
+	return harness
+}
+
+func (h *Harness) Name() string {
+	return "test"
+}
+
+func (h *Harness) Available() bool {
+	return true
+}
+
+// SupportsUser is true, but actions meant to run as another user run as the
+// current user
+func (h *Harness) SupportsUser() bool {
+	return true
+}
+
+func (h *Harness) Run(ctx context.Context, request *clicommon.EscalationRequest) error {
+	h.mu.Lock()
+	h.requests = append(h.requests, *request)
+	h.mu.Unlock()
+
+	if h.options.Subprocess {
+		if !mainCalled {
+			return errors.New("clicommontest.Options.Subprocess needs clicommontest.Main to be called from TestMain")
+		}
+
+		return runSubprocess(ctx, request)
+	}
+
+	// A sudo helper keeps swapping stdin and stdout until it exits, which
+	// would break the test's own output
+	if len(request.Args) >= 3 && request.Args[2] == helperActionName {
+		return errors.New("sudo helpers need clicommontest.Options.Subprocess")
+	}
+
+	return runInProcess(request)
+}
+
+func (h *Harness) RecordSudoCalls(records []clicommon.SudoCallRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.calls = append(h.calls, records...)
+}
+
+// Requests gets every escalation request the harness has run so far
+func (h *Harness) Requests() []clicommon.EscalationRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]clicommon.EscalationRequest(nil), h.requests...)
+}
+
+// Calls gets every action the harness has run so far, with its params and
+// result as they were sent to and from the elevated process
+func (h *Harness) Calls() []clicommon.SudoCallRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]clicommon.SudoCallRecord(nil), h.calls...)
+}
+
+// Actions gets the names of every action the harness has been asked to run so
+// far, in order, including ones that never ran
+func (h *Harness) Actions() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	names := make([]string, len(h.calls))
+	for i, call := range h.calls {
+		names[i] = call.Action
+	}
+
+	return names
+}
+
+// Reset forgets every request and action the harness has run so far
+func (h *Harness) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.requests = nil
+	h.calls = nil
+}
+
+func runSubprocess(ctx context.Context, request *clicommon.EscalationRequest) error {
+	cmd := exec.CommandContext(ctx, request.Executable, request.Args...)
+	cmd.Stdin = request.Stdin
+	cmd.Stdout = request.Stdout
+	cmd.Stderr = request.Stderr
+
+	if len(request.Env) > 0 {
+		cmd.Env = append(os.Environ(), request.Env...)
+	}
+
+	return cmd.Run()
+}
+
+// runInProcess handles a sudo call in this process, with the request's stdin
+// as os.Stdin while it runs, which is where the call's key is sent
+func runInProcess(request *clicommon.EscalationRequest) error {
+	inProcessLock.Lock()
+	defer inProcessLock.Unlock()
+
+	if request.Stdin != nil {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		go func() {
+			io.Copy(writer, request.Stdin)
+			writer.Close()
+		}()
+
+		stdin := os.Stdin
+		os.Stdin = reader
+		defer func() { os.Stdin = stdin }()
+	}
+
+	args := append([]string{request.Executable}, request.Args...)
+
+	_, err := clicommon.HandleSudo(args)
+
+	return err
+}
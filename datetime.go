@@ -0,0 +1,476 @@
+package clicommon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	dateTimePreviewLayout = "Mon, 02 Jan 2006 15:04 MST"
+
+	// Title, weekday names, and 6 rows of weeks
+	calendarHeight = 8
+)
+
+var (
+	relativePartRegex = regexp.MustCompile(`^(\d+)\s*([a-z]+)\s*`)
+	timeOfDayRegex    = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(?::(\d{2}))?\s*(am|pm)?$`)
+
+	relativeUnits = map[string]time.Duration{
+		"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+		"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+		"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	}
+
+	relativeDayUnits = map[string]int{
+		"d": 1, "day": 1, "days": 1,
+		"w": 7, "wk": 7, "wks": 7, "week": 7, "weeks": 7,
+	}
+
+	dateTimeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+	}
+
+	dateLayouts = []string{
+		"2006-01-02",
+		"2006/01/02",
+	}
+)
+
+// CliDateTime prompts for a date and time, which can be relative to now (like
+// "2h", "3 days ago", or "tomorrow 9am") or absolute (like "2026-11-01" or
+// "2026-11-01 14:30"). The parsed time is previewed and must be confirmed
+// before it's returned.
+func CliDateTime(question string) (time.Time, error) {
+	for {
+		answer, err := CliQuestionLine(question + " (e.g. 2h, tomorrow 9am, 2026-11-01)")
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		parsed, err := ParseDateTime(answer, time.Now())
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		fmt.Println("    " + parsed.Format(dateTimePreviewLayout))
+
+		if CliQuestionYesNoDefault("Is this correct?", true) {
+			return parsed, nil
+		}
+	}
+}
+
+// ParseDateTime parses a relative or absolute date and time the same way
+// CliDateTime does, using now as the base for relative inputs
+func ParseDateTime(input string, now time.Time) (time.Time, error) {
+	normalized := strings.ToLower(strings.Join(strings.Fields(input), " "))
+
+	switch normalized {
+	case "":
+		return time.Time{}, errors.New("no date or time entered")
+	case "now":
+		return now, nil
+	}
+
+	if parsed, ok := parseRelativeDateTime(normalized, now); ok {
+		return parsed, nil
+	}
+
+	for _, layout := range dateTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, strings.TrimSpace(input), now.Location()); err == nil {
+			return parsed, nil
+		}
+	}
+
+	day, timeOfDay := normalized, ""
+	if split := strings.IndexByte(normalized, ' '); split >= 0 {
+		day, timeOfDay = normalized[:split], normalized[split+1:]
+	}
+
+	if day == "next" || day == "last" {
+		// Keep "next monday" together as the day part
+		if split := strings.IndexByte(timeOfDay, ' '); split >= 0 {
+			day, timeOfDay = day+" "+timeOfDay[:split], timeOfDay[split+1:]
+		} else {
+			day, timeOfDay = normalized, ""
+		}
+	}
+
+	date, ok := parseDay(day, now)
+	if !ok {
+		// Maybe it's just a time of day, for today
+		if hour, minute, second, ok := parseTimeOfDay(normalized); ok {
+			return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, second, 0, now.Location()), nil
+		}
+
+		return time.Time{}, fmt.Errorf("could not understand date or time '%s'", input)
+	}
+
+	if timeOfDay == "" {
+		return date, nil
+	}
+
+	hour, minute, second, ok := parseTimeOfDay(timeOfDay)
+	if !ok {
+		return time.Time{}, fmt.Errorf("could not understand time of day '%s'", timeOfDay)
+	}
+
+	return time.Date(date.Year(), date.Month(), date.Day(), hour, minute, second, 0, date.Location()), nil
+}
+
+// parseRelativeDateTime parses durations like "2h30m", "in 3 days", "+1w", or
+// "2 hours ago" relative to now
+func parseRelativeDateTime(input string, now time.Time) (time.Time, bool) {
+	sign := 1
+
+	switch {
+	case strings.HasPrefix(input, "in "):
+		input = input[3:]
+	case strings.HasPrefix(input, "+"):
+		input = input[1:]
+	case strings.HasPrefix(input, "-"):
+		input, sign = input[1:], -1
+	case strings.HasSuffix(input, " ago"):
+		input, sign = strings.TrimSuffix(input, " ago"), -1
+	}
+
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return time.Time{}, false
+	}
+
+	var duration time.Duration
+	days := 0
+
+	for input != "" {
+		match := relativePartRegex.FindStringSubmatch(input)
+		if match == nil {
+			return time.Time{}, false
+		}
+
+		amount, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		if unit, ok := relativeUnits[match[2]]; ok {
+			duration += time.Duration(amount) * unit
+		} else if unitDays, ok := relativeDayUnits[match[2]]; ok {
+			days += amount * unitDays
+		} else {
+			return time.Time{}, false
+		}
+
+		input = input[len(match[0]):]
+	}
+
+	return now.AddDate(0, 0, sign*days).Add(time.Duration(sign) * duration), true
+}
+
+// parseDay parses a day like "today", "tomorrow", "next friday", or
+// "2026-11-01" into the start of that day
+func parseDay(day string, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch day {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), true
+	}
+
+	for _, layout := range dateLayouts {
+		if parsed, err := time.ParseInLocation(layout, day, now.Location()); err == nil {
+			return parsed, true
+		}
+	}
+
+	direction := 1
+	if strings.HasPrefix(day, "last ") {
+		day, direction = strings.TrimPrefix(day, "last "), -1
+	} else {
+		day = strings.TrimPrefix(day, "next ")
+	}
+
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+
+		if day != name && day != name[:3] {
+			continue
+		}
+
+		// Always move at least one day, so "monday" on a Monday is next week
+		date := today.AddDate(0, 0, direction)
+		for date.Weekday() != weekday {
+			date = date.AddDate(0, 0, direction)
+		}
+
+		return date, true
+	}
+
+	return time.Time{}, false
+}
+
+// parseTimeOfDay parses times like "9am", "9:30pm", "14:00", or "noon"
+func parseTimeOfDay(input string) (hour, minute, second int, ok bool) {
+	switch input {
+	case "noon":
+		return 12, 0, 0, true
+	case "midnight":
+		return 0, 0, 0, true
+	}
+
+	match := timeOfDayRegex.FindStringSubmatch(input)
+	if match == nil {
+		return 0, 0, 0, false
+	}
+
+	hour, _ = strconv.Atoi(match[1])
+	minute, _ = strconv.Atoi(match[2])
+	second, _ = strconv.Atoi(match[3])
+
+	switch match[4] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, 0, false
+		}
+
+		hour %= 12
+		if match[4] == "pm" {
+			hour += 12
+		}
+
+	default:
+		if match[2] == "" {
+			// A bare number like "9" is too ambiguous
+			return 0, 0, 0, false
+		}
+	}
+
+	if hour > 23 || minute > 59 || second > 59 {
+		return 0, 0, 0, false
+	}
+
+	return hour, minute, second, true
+}
+
+// CliCalendar provides an interactive calendar to pick a date, starting at
+// initial. Arrow keys move by day and week, Page Up/Down by month, Home/End go
+// to the start and end of the month, and 't' goes to today. The time of day of
+// initial is kept in the returned time.
+func CliCalendar(question string, initial time.Time) (time.Time, error) {
+	// This is not a fmt.Println() for the same reason as in CliChoice
+	fmt.Print(question)
+
+	cal, err := newCalendar(initial)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer cal.Finish()
+
+	err = cal.Run()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cal.selected, nil
+}
+
+type calendar struct {
+	origState *term.State
+	selected  time.Time
+}
+
+func newCalendar(initial time.Time) (*calendar, error) {
+	// Set the terminal into raw mode and store the original state for later
+	origState, err := term.MakeRaw(0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hide the cursor (if supported)
+	hideCursor()
+
+	// Make room for the calendar below the question and draw it
+	fmt.Println()
+
+	c := &calendar{
+		origState: origState,
+		selected:  initial,
+	}
+	c.draw(false)
+
+	return c, nil
+}
+
+func (c *calendar) Finish() {
+	// Restore the terminal state after this function exits
+	defer term.Restore(0, c.origState)
+
+	// Restore the cursor after this function exits
+	defer showCursor()
+
+	// Go to the start of the calendar, erase it, print the selected date, and
+	// reset the cursor to the start of the line for whatever comes next
+	prevLine(calendarHeight - 1)
+	eraseRemaining()
+	cursorRight(4)
+	fmt.Println(c.selected.Format("Mon, 02 Jan 2006"))
+	startOfLine()
+}
+
+func (c *calendar) Run() error {
+	// Same buffer sizing as the chooser, one keystroke per read
+	buf := make([]byte, 16)
+
+	for {
+		bytesRead, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		if bytesRead == 0 {
+			return errors.New("stdin closed")
+		}
+
+		switch string(buf[:bytesRead]) {
+		case "\r":
+			// Plain enter to select the date
+			return nil
+
+		case "\x03", "\x1b":
+			// Ctrl+C or Escape to cancel
+			return errors.New("operation cancelled")
+
+		case "\x1b[D":
+			// Left arrow
+			c.selected = c.selected.AddDate(0, 0, -1)
+
+		case "\x1b[C":
+			// Right arrow
+			c.selected = c.selected.AddDate(0, 0, 1)
+
+		case "\x1b[A":
+			// Up arrow
+			c.selected = c.selected.AddDate(0, 0, -7)
+
+		case "\x1b[B":
+			// Down arrow
+			c.selected = c.selected.AddDate(0, 0, 7)
+
+		case "\x1b[5~":
+			// Page up
+			c.selected = addMonthsClamped(c.selected, -1)
+
+		case "\x1b[6~":
+			// Page down
+			c.selected = addMonthsClamped(c.selected, 1)
+
+		case "\x1b[1~", "\x1b[7~", "\x1b[H":
+			// Home
+			c.selected = c.selected.AddDate(0, 0, 1-c.selected.Day())
+
+		case "\x1b[4~", "\x1b[8~", "\x1b[F":
+			// End
+			c.selected = c.selected.AddDate(0, 0, daysInMonth(c.selected)-c.selected.Day())
+
+		case "t", "T":
+			now := time.Now()
+			c.selected = time.Date(
+				now.Year(), now.Month(), now.Day(),
+				c.selected.Hour(), c.selected.Minute(), c.selected.Second(), 0,
+				c.selected.Location(),
+			)
+
+		default:
+			continue
+		}
+
+		c.draw(true)
+	}
+}
+
+// draw prints the month of the selected date with the selected day highlighted,
+// leaving the cursor on the last line. If redraw is true the previously drawn
+// calendar is overwritten.
+func (c *calendar) draw(redraw bool) {
+	if redraw {
+		prevLine(calendarHeight - 1)
+	}
+
+	first := c.selected.AddDate(0, 0, 1-c.selected.Day())
+	// Weeks start on Monday
+	offset := (int(first.Weekday()) + 6) % 7
+	days := daysInMonth(c.selected)
+
+	lines := []func(){
+		func() { fmt.Print(c.selected.Format("January 2006")) },
+		func() { fmt.Print("Mo Tu We Th Fr Sa Su") },
+	}
+
+	for week := 0; week < 6; week++ {
+		week := week
+
+		lines = append(lines, func() {
+			for weekday := 0; weekday < 7; weekday++ {
+				day := week*7 + weekday - offset + 1
+
+				if weekday > 0 {
+					fmt.Print(" ")
+				}
+
+				if day < 1 || day > days {
+					fmt.Print("  ")
+				} else if day == c.selected.Day() {
+					highlight()
+					fmt.Printf("%2d", day)
+					reset()
+				} else {
+					fmt.Printf("%2d", day)
+				}
+			}
+		})
+	}
+
+	for i, line := range lines {
+		if i > 0 {
+			fmt.Print("\n")
+		}
+
+		startOfLine()
+		eraseLine()
+		cursorRight(4)
+		line()
+	}
+}
+
+func daysInMonth(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+}
+
+// addMonthsClamped moves t by a number of months, keeping the day of the month
+// unless the new month is too short for it
+func addMonthsClamped(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+
+	day := t.Day()
+	if lastDay := daysInMonth(first); day > lastDay {
+		day = lastDay
+	}
+
+	return first.AddDate(0, 0, day-1)
+}
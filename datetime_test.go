@@ -0,0 +1,101 @@
+package clicommon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateTime(t *testing.T) {
+	zone := time.FixedZone("TEST", -6*60*60)
+
+	// A Wednesday
+	now := time.Date(2026, time.October, 14, 10, 0, 0, 0, zone)
+
+	date := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, zone)
+	}
+
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"now", now},
+		{"  NOW ", now},
+
+		{"2h", now.Add(2 * time.Hour)},
+		{"2h30m", now.Add(2*time.Hour + 30*time.Minute)},
+		{"1 hour 15 mins", now.Add(75 * time.Minute)},
+		{"in 3 days", now.AddDate(0, 0, 3)},
+		{"+1w", now.AddDate(0, 0, 7)},
+		{"-90 min", now.Add(-90 * time.Minute)},
+		{"2 hours ago", now.Add(-2 * time.Hour)},
+		{"1 week ago", now.AddDate(0, 0, -7)},
+
+		{"today", date(time.October, 14, 0, 0)},
+		{"tomorrow", date(time.October, 15, 0, 0)},
+		{"yesterday noon", date(time.October, 13, 12, 0)},
+		{"tomorrow 9am", date(time.October, 15, 9, 0)},
+		{"Tomorrow   9:30PM", date(time.October, 15, 21, 30)},
+		{"today 12am", date(time.October, 14, 0, 0)},
+		{"today 12pm", date(time.October, 14, 12, 0)},
+
+		{"friday", date(time.October, 16, 0, 0)},
+		{"wed", date(time.October, 21, 0, 0)},
+		{"next monday", date(time.October, 19, 0, 0)},
+		{"last monday", date(time.October, 12, 0, 0)},
+		{"last wednesday", date(time.October, 7, 0, 0)},
+		{"next monday 14:00", date(time.October, 19, 14, 0)},
+
+		{"14:30", date(time.October, 14, 14, 30)},
+		{"7pm", date(time.October, 14, 19, 0)},
+		{"midnight", date(time.October, 14, 0, 0)},
+
+		{"2026-11-01", date(time.November, 1, 0, 0)},
+		{"2026/11/01 8pm", date(time.November, 1, 20, 0)},
+		{"2026-11-01 14:30", date(time.November, 1, 14, 30)},
+		{"2026-11-01T14:30", date(time.November, 1, 14, 30)},
+		{"2026-11-01 14:30:15", time.Date(2026, time.November, 1, 14, 30, 15, 0, zone)},
+		{"2026-11-01T14:30:00Z", time.Date(2026, time.November, 1, 14, 30, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		got, err := ParseDateTime(test.input, now)
+		if err != nil {
+			t.Errorf("ParseDateTime(%q) failed: %v", test.input, err)
+			continue
+		}
+
+		if !got.Equal(test.want) {
+			t.Errorf("ParseDateTime(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseDateTimeInvalid(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC)
+
+	inputs := []string{
+		"",
+		"   ",
+		"9",
+		"13pm",
+		"0am",
+		"25:00",
+		"12:60",
+		"tomorrow 25:00",
+		"tomorrow evening",
+		"someday",
+		"2 fortnights",
+		"in",
+		"ago",
+		"next",
+		"2026-13-01",
+	}
+
+	for _, input := range inputs {
+		got, err := ParseDateTime(input, now)
+		if err == nil {
+			t.Errorf("ParseDateTime(%q) = %v, want an error", input, got)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package clicommon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	escalationConfigName = "escalation"
+
+	// How long an escalated command has to exit after being asked to stop,
+	// before it's killed
+	escalationKillDelay = 5 * time.Second
+)
+
+// EscalationRequest is a command to run with superuser permissions
+type EscalationRequest struct {
+	Executable string
+	Args       []string
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Env is added to this process's environment for the escalator
+	Env []string
+
+	// Signals are forwarded to the escalator while it runs, which passes them
+	// on to the elevated process
+	Signals <-chan os.Signal
+
+	// User is who the command runs as, or root if it's empty. Only escalators
+	// that implement UserEscalator are given a request with a User.
+	User string
+}
+
+// EscalationPrompt is how escalators may ask the user for their password
+type EscalationPrompt int
+
+const (
+	// EscalationPromptDefault lets the escalator ask for a password however it
+	// normally does, usually on the terminal
+	EscalationPromptDefault EscalationPrompt = iota
+
+	// EscalationPromptNever never asks for a password, like sudo -n, failing
+	// with ErrPasswordRequired instead if one is needed. This is for
+	// automation with passwordless sudo.
+	EscalationPromptNever
+
+	// EscalationPromptAskpass asks for the password with CliQuestionHidden,
+	// by running this program as sudo's askpass program. Only sudo supports
+	// this.
+	EscalationPromptAskpass
+)
+
+// EscalationOptions configures how escalators run, see SetEscalationOptions
+type EscalationOptions struct {
+	Prompt EscalationPrompt
+
+	// AllowNoTerminal lets escalators that normally ask for a password on
+	// the terminal run when stdout isn't one, like with passwordless sudo.
+	// This is implied by EscalationPromptNever and EscalationPromptAskpass.
+	AllowNoTerminal bool
+}
+
+// ErrPasswordRequired is returned in EscalationPromptNever mode when the
+// escalator can't run the command without a password. That's recognized by the
+// escalator's own error message, so the command's own errors are returned as
+// they are.
+var ErrPasswordRequired = errors.New("a password is required for superuser permissions")
+
+// Escalator runs commands with superuser permissions through some mechanism,
+// like sudo or pkexec
+type Escalator interface {
+	// Name is the name the user can pick this escalator by
+	Name() string
+
+	// Available reports if this escalator can be used on this system
+	Available() bool
+
+	// Run runs the command and waits for it to exit
+	Run(ctx context.Context, request *EscalationRequest) error
+}
+
+// UserEscalator is implemented by escalators that can run commands as a user
+// other than root, like sudo -u, for CallSudoAs
+type UserEscalator interface {
+	Escalator
+
+	// SupportsUser reports if Run honors the request's User
+	SupportsUser() bool
+}
+
+// SudoCallRecord is a single action that was run through an escalator, as it
+// was sent to and returned from the elevated process
+type SudoCallRecord struct {
+	Action string
+
+	// Params are a SudoAction's params
+	Params []string
+
+	// Data is a TypedSudoAction encoded as JSON
+	Data json.RawMessage
+
+	// User is who the action ran as, or empty for root
+	User string
+
+	// Result is a TypedSudoAction's result encoded as JSON, if it succeeded
+	Result json.RawMessage
+
+	// Err is the action's error, or why it never ran
+	Err error
+
+	// Ran is unset if the action never ran, because the elevated process
+	// didn't respond or an earlier action in the same batch failed
+	Ran bool
+}
+
+// SudoCallRecorder is implemented by escalators that keep track of the actions
+// they ran, like clicommontest.Harness. RecordSudoCalls is called with every call's
+// actions once it's done.
+type SudoCallRecorder interface {
+	RecordSudoCalls(records []SudoCallRecord)
+}
+
+type escalationConfig struct {
+	Escalator string `json:"escalator"`
+}
+
+var (
+	escalatorLock      sync.Mutex
+	forcedEscalator    Escalator
+	preferredEscalator string
+	escalationOptions  EscalationOptions
+)
+
+// Escalators lists every escalator supported on this platform, in the order
+// they're tried when detecting one. An escalator that skips escalation when the
+// process already has superuser permissions always comes first.
+func Escalators() []Escalator {
+	return platformEscalators()
+}
+
+// SetEscalator makes CallSudo always use the given escalator, e.g. a
+// clicommontest.Harness in tests. Passing nil goes back to detecting one.
+func SetEscalator(escalator Escalator) {
+	escalatorLock.Lock()
+	defer escalatorLock.Unlock()
+
+	forcedEscalator = escalator
+}
+
+// SetEscalationOptions changes how escalators ask for passwords, e.g. to never
+// ask in CI
+func SetEscalationOptions(options EscalationOptions) {
+	escalatorLock.Lock()
+	defer escalatorLock.Unlock()
+
+	escalationOptions = options
+}
+
+func currentEscalationOptions() EscalationOptions {
+	escalatorLock.Lock()
+	defer escalatorLock.Unlock()
+
+	return escalationOptions
+}
+
+// LoadEscalatorPreference makes CallSudo prefer the escalator the user picked
+// with SetEscalatorPreference, as long as it's available
+func LoadEscalatorPreference(configDir *UserConfigDir) error {
+	config := escalationConfig{}
+
+	err := configDir.LoadConfig(escalationConfigName, &config)
+	if err != nil {
+		return err
+	}
+
+	escalatorLock.Lock()
+	defer escalatorLock.Unlock()
+
+	preferredEscalator = config.Escalator
+
+	return nil
+}
+
+// SetEscalatorPreference saves which escalator the user prefers, by name, and
+// starts preferring it right away. An empty name clears the preference.
+func SetEscalatorPreference(configDir *UserConfigDir, name string) error {
+	if name != "" {
+		found := false
+
+		for _, escalator := range Escalators() {
+			if escalator.Name() == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("unknown escalator '%s'", name)
+		}
+	}
+
+	err := configDir.SaveConfig(escalationConfigName, &escalationConfig{
+		Escalator: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	escalatorLock.Lock()
+	defer escalatorLock.Unlock()
+
+	preferredEscalator = name
+
+	return nil
+}
+
+// DetectEscalator picks the escalator CallSudo uses. That's the one given to
+// SetEscalator if any, otherwise the first available one, except that the
+// user's preferred escalator wins over everything but skipping escalation
+// altogether when the process already has superuser permissions.
+func DetectEscalator() (Escalator, error) {
+	escalatorLock.Lock()
+	defer escalatorLock.Unlock()
+
+	if forcedEscalator != nil {
+		return forcedEscalator, nil
+	}
+
+	var available []Escalator
+
+	for _, escalator := range Escalators() {
+		if escalator.Available() {
+			available = append(available, escalator)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, errors.New("no privilege escalation method found on this system")
+	}
+
+	if _, ok := available[0].(alreadyElevatedEscalator); ok {
+		return available[0], nil
+	}
+
+	if preferredEscalator != "" {
+		for _, escalator := range available {
+			if escalator.Name() == preferredEscalator {
+				return escalator, nil
+			}
+		}
+
+		Log.Warn("Preferred escalator is not available", "escalator", preferredEscalator)
+	}
+
+	return available[0], nil
+}
+
+// exitCodeError is returned by escalators that can't return an *exec.ExitError
+// when the elevated process exits with an error
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("elevated process exited with code %d", e.code)
+}
+
+// escalationExitCode gets the exit code of an elevated process from the error
+// its escalator returned, if it exited by itself
+func escalationExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode(), true
+	}
+
+	var codeErr *exitCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.code, true
+	}
+
+	return 0, false
+}
+
+// alreadyElevatedEscalator is implemented by escalators that run commands
+// directly because the process already has superuser permissions
+type alreadyElevatedEscalator interface {
+	alreadyElevated()
+}
+
+// detachedEscalator is implemented by escalators that can't connect the
+// elevated process to the request's stdin, stdout and stderr
+type detachedEscalator interface {
+	detached()
+}
+
+// credentialCachingEscalator is implemented by escalators that cache the user's
+// password for a while, like sudo, so it can be kept cached by a SudoKeepalive
+type credentialCachingEscalator interface {
+	cachesCredentials() bool
+	validateCredentials(ctx context.Context, interactive bool) error
+}
+
+func runUnescalated(ctx context.Context, request *EscalationRequest) error {
+	return runEscalationCommand(ctx, request.Executable, request.Args, request)
+}
+
+// runEscalationCommand runs a command for an escalator, forwarding the
+// request's signals to it. When ctx is done the command is asked to stop, and
+// killed if it doesn't in time, since escalators like sudo pass on the request
+// to stop to the elevated process but can't pass on being killed.
+func runEscalationCommand(ctx context.Context, name string, args []string, request *EscalationRequest) error {
+	return runEscalationCmd(ctx, newEscalationCmd(name, args, request), request)
+}
+
+// newEscalationCmd creates a command for an escalator, connected to the
+// request's stdin, stdout and stderr
+func newEscalationCmd(name string, args []string, request *EscalationRequest) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = request.Stdin
+	cmd.Stdout = request.Stdout
+	cmd.Stderr = request.Stderr
+
+	if len(request.Env) > 0 {
+		cmd.Env = append(os.Environ(), request.Env...)
+	}
+
+	return cmd
+}
+
+// runEscalationCmd runs a command from newEscalationCmd like
+// runEscalationCommand
+func runEscalationCmd(ctx context.Context, cmd *exec.Cmd, request *EscalationRequest) error {
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-done:
+				return
+
+			case sig := <-request.Signals:
+				cmd.Process.Signal(sig)
+
+			case <-ctx.Done():
+				err := cmd.Process.Signal(syscall.SIGTERM)
+				if err != nil {
+					cmd.Process.Kill()
+					return
+				}
+
+				select {
+				case <-done:
+				case <-time.After(escalationKillDelay):
+					cmd.Process.Kill()
+				}
+
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	close(done)
+	<-stopped
+
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
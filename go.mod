@@ -7,4 +7,5 @@ require (
 	github.com/mattn/go-isatty v0.0.13
 	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
 	golang.org/x/term v0.0.0-20210615171337-6886f2dfbf5b
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,400 @@
+package clicommon
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogFileMaxSize  = 1024 * 1024
+	defaultLogFileMaxFiles = 3
+
+	logFilePermissions = 0600
+
+	redactedSecret = "[REDACTED]"
+)
+
+// LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	LevelTrace LogLevel = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LevelTrace: "trace",
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// Log is the default logger, which the rest of this package also logs to. It
+// writes info messages and above to stderr until configured otherwise.
+var Log = NewLogger(os.Stderr)
+
+var (
+	secretsLock sync.RWMutex
+	secrets     []string
+)
+
+// Logger writes leveled log messages with key/value fields to the console and
+// optionally to a size-limited log file
+type Logger struct {
+	core   *loggerCore
+	fields []interface{}
+}
+
+type loggerCore struct {
+	mu        sync.Mutex
+	out       io.Writer
+	level     LogLevel
+	file      *rotatingLogFile
+	fileLevel LogLevel
+}
+
+// LogFileOptions configures a log file opened with Logger.OpenLogFile
+type LogFileOptions struct {
+	// Level is the lowest level written to the file, independent of the
+	// console level. Defaults to LevelTrace.
+	Level LogLevel
+
+	// MaxSize is the size in bytes the file may grow to before it's rotated.
+	// Defaults to 1 MiB.
+	MaxSize int64
+
+	// MaxFiles is how many rotated files are kept besides the current one.
+	// Defaults to 3.
+	MaxFiles int
+}
+
+type rotatingLogFile struct {
+	path     string
+	file     *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+}
+
+func (level LogLevel) String() string {
+	if name, ok := logLevelNames[level]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("level(%d)", int(level))
+}
+
+// ParseLogLevel parses a log level name, e.g. from a --log-level flag
+func ParseLogLevel(name string) (LogLevel, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+
+	if normalized == "warning" {
+		return LevelWarn, nil
+	}
+
+	for level, levelName := range logLevelNames {
+		if normalized == levelName {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level '%s'", name)
+}
+
+// RegisterSecret makes every logger replace any occurrence of secret in log
+// messages and fields with a redaction marker
+func RegisterSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	for _, existing := range secrets {
+		if existing == secret {
+			return
+		}
+	}
+
+	secrets = append(secrets, secret)
+}
+
+// Redact replaces every registered secret in s with a redaction marker
+func Redact(s string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+	}
+
+	return s
+}
+
+// NewLogger creates a logger that writes info messages and above to out
+func NewLogger(out io.Writer) *Logger {
+	return &Logger{
+		core: &loggerCore{
+			out:   out,
+			level: LevelInfo,
+		},
+	}
+}
+
+// SetLevel sets the lowest level that gets written to the console
+func (logger *Logger) SetLevel(level LogLevel) {
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+
+	logger.core.level = level
+}
+
+// Level gets the lowest level that gets written to the console
+func (logger *Logger) Level() LogLevel {
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+
+	return logger.core.level
+}
+
+// SetVerbosity sets the console level from the number of times -v and -q style
+// flags were given, where each -v shows one more level and each -q hides one
+func (logger *Logger) SetVerbosity(verbose, quiet int) {
+	level := LevelInfo - LogLevel(verbose) + LogLevel(quiet)
+
+	if level < LevelTrace {
+		level = LevelTrace
+	} else if level > LevelError {
+		level = LevelError
+	}
+
+	logger.SetLevel(level)
+}
+
+// SetOutput changes where console log messages are written
+func (logger *Logger) SetOutput(out io.Writer) {
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+
+	logger.core.out = out
+}
+
+// With returns a logger that adds the given key/value fields to every message,
+// while sharing its level and outputs with the original logger
+func (logger *Logger) With(fields ...interface{}) *Logger {
+	combined := make([]interface{}, 0, len(logger.fields)+len(fields))
+	combined = append(combined, logger.fields...)
+	combined = append(combined, fields...)
+
+	return &Logger{
+		core:   logger.core,
+		fields: combined,
+	}
+}
+
+// OpenLogFile starts also writing log messages to a file named after the
+// config dir inside of its state directory. When the file grows past the
+// configured size it's rotated, keeping a limited number of older files.
+func (logger *Logger) OpenLogFile(configDir *UserConfigDir, options LogFileOptions) error {
+	dir, err := configDir.GetStateDir()
+	if err != nil {
+		return err
+	}
+
+	if options.MaxSize <= 0 {
+		options.MaxSize = defaultLogFileMaxSize
+	}
+
+	if options.MaxFiles <= 0 {
+		options.MaxFiles = defaultLogFileMaxFiles
+	}
+
+	file := &rotatingLogFile{
+		path:     filepath.Join(dir, configDir.name+".log"),
+		maxSize:  options.MaxSize,
+		maxFiles: options.MaxFiles,
+	}
+
+	err = file.open()
+	if err != nil {
+		return err
+	}
+
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+
+	if logger.core.file != nil {
+		logger.core.file.close()
+	}
+
+	logger.core.file = file
+	logger.core.fileLevel = options.Level
+
+	return nil
+}
+
+// Close closes the log file, if one was opened
+func (logger *Logger) Close() error {
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+
+	if logger.core.file == nil {
+		return nil
+	}
+
+	err := logger.core.file.close()
+	logger.core.file = nil
+
+	return err
+}
+
+// Trace logs a message with key/value fields at the trace level
+func (logger *Logger) Trace(msg string, fields ...interface{}) {
+	logger.log(LevelTrace, msg, fields)
+}
+
+// Debug logs a message with key/value fields at the debug level
+func (logger *Logger) Debug(msg string, fields ...interface{}) {
+	logger.log(LevelDebug, msg, fields)
+}
+
+// Info logs a message with key/value fields at the info level
+func (logger *Logger) Info(msg string, fields ...interface{}) {
+	logger.log(LevelInfo, msg, fields)
+}
+
+// Warn logs a message with key/value fields at the warn level
+func (logger *Logger) Warn(msg string, fields ...interface{}) {
+	logger.log(LevelWarn, msg, fields)
+}
+
+// Error logs a message with key/value fields at the error level
+func (logger *Logger) Error(msg string, fields ...interface{}) {
+	logger.log(LevelError, msg, fields)
+}
+
+func (logger *Logger) log(level LogLevel, msg string, fields []interface{}) {
+	core := logger.core
+
+	core.mu.Lock()
+	defer core.mu.Unlock()
+
+	toConsole := level >= core.level
+	toFile := core.file != nil && level >= core.fileLevel
+
+	if !toConsole && !toFile {
+		return
+	}
+
+	line := Redact(msg + formatLogFields(append(logger.fields[:len(logger.fields):len(logger.fields)], fields...)))
+
+	if toConsole {
+		if level == LevelInfo {
+			fmt.Fprintln(core.out, line)
+		} else {
+			fmt.Fprintf(core.out, "%s: %s\n", level, line)
+		}
+	}
+
+	if toFile {
+		// A broken log file shouldn't break the program, so just drop it
+		err := core.file.write(fmt.Sprintf(
+			"%s %-5s %s\n",
+			time.Now().Format(time.RFC3339),
+			strings.ToUpper(level.String()),
+			line,
+		))
+		if err != nil {
+			core.file.close()
+			core.file = nil
+		}
+	}
+}
+
+func formatLogFields(fields []interface{}) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+
+		var value string
+		if i+1 < len(fields) {
+			value = fmt.Sprint(fields[i+1])
+		} else {
+			value = "(missing)"
+		}
+
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		fmt.Fprintf(&builder, " %s=%s", key, value)
+	}
+
+	return builder.String()
+}
+
+func (file *rotatingLogFile) open() error {
+	f, err := os.OpenFile(file.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, logFilePermissions)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	file.file = f
+	file.size = info.Size()
+
+	return nil
+}
+
+func (file *rotatingLogFile) write(line string) error {
+	if file.size > 0 && file.size+int64(len(line)) > file.maxSize {
+		err := file.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := file.file.WriteString(line)
+	file.size += int64(n)
+
+	return err
+}
+
+// rotate renames the current log file to .1, the old .1 to .2 and so on,
+// deleting the oldest file, and then starts a new empty log file
+func (file *rotatingLogFile) rotate() error {
+	file.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", file.path, file.maxFiles))
+
+	for i := file.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", file.path, i), fmt.Sprintf("%s.%d", file.path, i+1))
+	}
+
+	err := os.Rename(file.path, file.path+".1")
+	if err != nil {
+		return err
+	}
+
+	return file.open()
+}
+
+func (file *rotatingLogFile) close() error {
+	return file.file.Close()
+}
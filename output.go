@@ -0,0 +1,387 @@
+package clicommon
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-isatty"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormat is a way of rendering command output, usually picked by the
+// user with an --output flag
+type OutputFormat string
+
+const (
+	OutputTable OutputFormat = "table"
+	OutputJSON  OutputFormat = "json"
+	OutputYAML  OutputFormat = "yaml"
+	OutputCSV   OutputFormat = "csv"
+
+	outputTag            = "output"
+	outputColumnGap      = 2
+	outputMinColumnWidth = 6
+)
+
+// OutputFormats lists every supported output format, e.g. for help text
+var OutputFormats = []OutputFormat{OutputTable, OutputJSON, OutputYAML, OutputCSV}
+
+// OutputOptions configures how RenderOutput renders rows
+type OutputOptions struct {
+	// Format is the output format, defaulting to a table
+	Format OutputFormat
+
+	// Columns selects and orders the table and CSV columns by their header
+	// names (case-insensitive). All columns are shown if this is empty.
+	Columns []string
+
+	// Width is the maximum table width. If 0 it's the terminal width when
+	// writing to a terminal, otherwise the table isn't limited.
+	Width int
+
+	// Wrap wraps long table cells onto multiple lines instead of truncating
+	// them
+	Wrap bool
+}
+
+type outputColumn struct {
+	header string
+	index  []int
+}
+
+// ParseOutputFormat parses an output format name, e.g. from an --output flag
+func ParseOutputFormat(format string) (OutputFormat, error) {
+	normalized := OutputFormat(strings.ToLower(strings.TrimSpace(format)))
+
+	if normalized == "yml" {
+		return OutputYAML, nil
+	}
+
+	for _, known := range OutputFormats {
+		if normalized == known {
+			return known, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown output format '%s'", format)
+}
+
+// RenderOutput renders rows, a slice of structs or struct pointers, to w in
+// the given format. JSON and YAML output encode the structs as-is, while table
+// and CSV columns are taken from the exported struct fields with the header
+// name set by an `output:"NAME"` tag. Fields tagged with `output:"-"` are
+// skipped.
+func RenderOutput(w io.Writer, rows interface{}, options OutputOptions) error {
+	switch options.Format {
+	case OutputJSON:
+		text, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w, string(text))
+		return err
+
+	case OutputYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+
+		err := encoder.Encode(rows)
+		if err != nil {
+			return err
+		}
+
+		return encoder.Close()
+	}
+
+	columns, records, err := outputRecords(rows, options.Columns)
+	if err != nil {
+		return err
+	}
+
+	switch options.Format {
+	case OutputTable, "":
+		width := options.Width
+
+		if width == 0 {
+			if file, ok := w.(*os.File); ok && isatty.IsTerminal(file.Fd()) {
+				width, _, _ = term.GetSize(int(file.Fd()))
+			}
+		}
+
+		return renderTable(w, columns, records, width, options.Wrap)
+
+	case OutputCSV:
+		writer := csv.NewWriter(w)
+
+		headers := make([]string, len(columns))
+		for i, column := range columns {
+			headers[i] = column.header
+		}
+
+		err = writer.Write(headers)
+		if err != nil {
+			return err
+		}
+
+		err = writer.WriteAll(records)
+		if err != nil {
+			return err
+		}
+
+		return writer.Error()
+	}
+
+	return fmt.Errorf("unknown output format '%s'", options.Format)
+}
+
+// outputRecords converts rows into their column definitions and the string
+// value of every cell
+func outputRecords(rows interface{}, selected []string) ([]outputColumn, [][]string, error) {
+	value := reflect.ValueOf(rows)
+
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, nil, errors.New("output rows must be a slice of structs")
+	}
+
+	rowType := value.Type().Elem()
+	if rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+
+	if rowType.Kind() != reflect.Struct {
+		return nil, nil, errors.New("output rows must be a slice of structs")
+	}
+
+	columns, err := outputColumns(rowType, selected)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([][]string, 0, value.Len())
+
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				continue
+			}
+
+			row = row.Elem()
+		}
+
+		record := make([]string, len(columns))
+		for j, column := range columns {
+			record[j] = outputCell(row.FieldByIndex(column.index))
+		}
+
+		records = append(records, record)
+	}
+
+	return columns, records, nil
+}
+
+func outputColumns(rowType reflect.Type, selected []string) ([]outputColumn, error) {
+	var columns []outputColumn
+
+	for i := 0; i < rowType.NumField(); i++ {
+		field := rowType.Field(i)
+
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		header := field.Tag.Get(outputTag)
+
+		if header == "-" {
+			continue
+		} else if header == "" {
+			header = field.Name
+		}
+
+		columns = append(columns, outputColumn{
+			header: header,
+			index:  field.Index,
+		})
+	}
+
+	if len(selected) == 0 {
+		return columns, nil
+	}
+
+	picked := make([]outputColumn, 0, len(selected))
+
+SELECTED:
+	for _, name := range selected {
+		for _, column := range columns {
+			if strings.EqualFold(column.header, strings.TrimSpace(name)) {
+				picked = append(picked, column)
+				continue SELECTED
+			}
+		}
+
+		return nil, fmt.Errorf("unknown output column '%s'", name)
+	}
+
+	return picked, nil
+}
+
+func outputCell(value reflect.Value) string {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return ""
+		}
+
+		value = value.Elem()
+	}
+
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		items := make([]string, value.Len())
+		for i := range items {
+			items[i] = outputCell(value.Index(i))
+		}
+
+		return strings.Join(items, ", ")
+	}
+
+	return strings.Join(strings.Fields(fmt.Sprint(value.Interface())), " ")
+}
+
+func renderTable(w io.Writer, columns []outputColumn, records [][]string, maxWidth int, wrap bool) error {
+	widths := make([]int, len(columns))
+
+	for i, column := range columns {
+		widths[i] = utf8.RuneCountInString(column.header)
+
+		for _, record := range records {
+			if width := utf8.RuneCountInString(record[i]); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+
+	if maxWidth > 0 {
+		fitColumnWidths(widths, maxWidth)
+	}
+
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = strings.ToUpper(column.header)
+	}
+
+	err := writeTableRow(w, headers, widths, wrap)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		err = writeTableRow(w, record, widths, wrap)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fitColumnWidths shrinks the widest columns until the whole table, including
+// the gaps between columns, fits within maxWidth or every column is already at
+// the minimum width
+func fitColumnWidths(widths []int, maxWidth int) {
+	total := outputColumnGap * (len(widths) - 1)
+	for _, width := range widths {
+		total += width
+	}
+
+	for total > maxWidth {
+		widest := 0
+		for i, width := range widths {
+			if width > widths[widest] {
+				widest = i
+			}
+		}
+
+		if widths[widest] <= outputMinColumnWidth {
+			return
+		}
+
+		widths[widest]--
+		total--
+	}
+}
+
+func writeTableRow(w io.Writer, cells []string, widths []int, wrap bool) error {
+	lines := make([][]string, len(cells))
+	height := 1
+
+	for i, cell := range cells {
+		if wrap {
+			lines[i] = wrapCell(cell, widths[i])
+		} else {
+			lines[i] = []string{truncateToWidth(cell, widths[i])}
+		}
+
+		if len(lines[i]) > height {
+			height = len(lines[i])
+		}
+	}
+
+	for line := 0; line < height; line++ {
+		var builder strings.Builder
+
+		for i := range cells {
+			var text string
+			if line < len(lines[i]) {
+				text = lines[i][line]
+			}
+
+			if i < len(cells)-1 {
+				fmt.Fprintf(&builder, "%-*s%s", widths[i], text, strings.Repeat(" ", outputColumnGap))
+			} else {
+				builder.WriteString(text)
+			}
+		}
+
+		_, err := fmt.Fprintln(w, strings.TrimRight(builder.String(), " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wrapCell splits a cell into lines no longer than width characters, breaking
+// at spaces where possible
+func wrapCell(cell string, width int) []string {
+	var lines []string
+
+	runes := []rune(cell)
+
+	for len(runes) > width {
+		split := width
+		for split > 0 && runes[split] != ' ' {
+			split--
+		}
+
+		if split == 0 {
+			lines = append(lines, string(runes[:width]))
+			runes = runes[width:]
+		} else {
+			lines = append(lines, string(runes[:split]))
+			runes = runes[split+1:]
+		}
+	}
+
+	return append(lines, string(runes))
+}
@@ -0,0 +1,243 @@
+package clicommon
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type outputTestRow struct {
+	Name   string `output:"name"`
+	Note   string `output:"note"`
+	Hidden string `output:"-"`
+	secret string
+}
+
+var outputTestRows = []outputTestRow{
+	{Name: "api", Note: "ok", Hidden: "x", secret: "y"},
+	{Name: "worker", Note: "failed to start"},
+}
+
+func TestRenderOutputTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    interface{}
+		options OutputOptions
+		want    []string
+	}{
+		{
+			name: "unlimited",
+			rows: outputTestRows,
+			want: []string{
+				"NAME    NOTE",
+				"api     ok",
+				"worker  failed to start",
+			},
+		},
+		{
+			name:    "fits",
+			rows:    outputTestRows,
+			options: OutputOptions{Width: 23},
+			want: []string{
+				"NAME    NOTE",
+				"api     ok",
+				"worker  failed to start",
+			},
+		},
+		{
+			name:    "truncated",
+			rows:    outputTestRows,
+			options: OutputOptions{Width: 20},
+			want: []string{
+				"NAME    NOTE",
+				"api     ok",
+				"worker  failed to...",
+			},
+		},
+		{
+			name:    "wrapped at spaces",
+			rows:    outputTestRows,
+			options: OutputOptions{Width: 20, Wrap: true},
+			want: []string{
+				"NAME    NOTE",
+				"api     ok",
+				"worker  failed to",
+				"        start",
+			},
+		},
+		{
+			name:    "minimum width",
+			rows:    outputTestRows,
+			options: OutputOptions{Width: 5},
+			want: []string{
+				"NAME    NOTE",
+				"api     ok",
+				"worker  fai...",
+			},
+		},
+		{
+			name:    "over-long word wrapped",
+			rows:    []outputTestRow{{Name: "a", Note: "abcdefghijklmnop"}},
+			options: OutputOptions{Width: 14, Wrap: true},
+			want: []string{
+				"NAME  NOTE",
+				"a     abcdefgh",
+				"      ijklmnop",
+			},
+		},
+		{
+			name:    "selected columns",
+			rows:    outputTestRows,
+			options: OutputOptions{Columns: []string{" NOTE ", "name"}},
+			want: []string{
+				"NOTE             NAME",
+				"ok               api",
+				"failed to start  worker",
+			},
+		},
+		{
+			name: "pointers",
+			rows: []*outputTestRow{{Name: "api", Note: "ok"}, nil},
+			want: []string{
+				"NAME  NOTE",
+				"api   ok",
+			},
+		},
+		{
+			name:    "multibyte characters",
+			rows:    []outputTestRow{{Name: "Zoë", Note: "héllo wörld"}, {Name: "abc", Note: "plain"}},
+			options: OutputOptions{Width: 13},
+			want: []string{
+				"NAME  NOTE",
+				"Zoë   héll...",
+				"abc   plain",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		err := RenderOutput(&buf, test.rows, test.options)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		want := strings.Join(test.want, "\n") + "\n"
+		if buf.String() != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, buf.String(), want)
+		}
+	}
+}
+
+func TestRenderOutputCSV(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := RenderOutput(&buf, outputTestRows, OutputOptions{Format: OutputCSV, Columns: []string{"note"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "note\nok\nfailed to start\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestRenderOutputErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    interface{}
+		options OutputOptions
+	}{
+		{"unknown column", outputTestRows, OutputOptions{Columns: []string{"missing"}}},
+		{"hidden column", outputTestRows, OutputOptions{Columns: []string{"Hidden"}}},
+		{"unexported column", outputTestRows, OutputOptions{Columns: []string{"secret"}}},
+		{"unknown CSV column", outputTestRows, OutputOptions{Format: OutputCSV, Columns: []string{"missing"}}},
+		{"unknown format", outputTestRows, OutputOptions{Format: "xml"}},
+		{"not a slice", outputTestRows[0], OutputOptions{}},
+		{"not structs", []string{"a"}, OutputOptions{}},
+	}
+
+	for _, test := range tests {
+		err := RenderOutput(&bytes.Buffer{}, test.rows, test.options)
+		if err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestFitColumnWidths(t *testing.T) {
+	tests := []struct {
+		widths   []int
+		maxWidth int
+		want     []int
+	}{
+		{[]int{4, 20}, 30, []int{4, 20}},
+		{[]int{4, 20}, 26, []int{4, 20}},
+		{[]int{4, 20}, 16, []int{4, 10}},
+		{[]int{3, 30}, 20, []int{3, 15}},
+		{[]int{10, 10}, 20, []int{9, 9}},
+		{[]int{10, 10}, 12, []int{6, 6}},
+		{[]int{20, 8, 20}, 30, []int{9, 8, 9}},
+	}
+
+	for _, test := range tests {
+		widths := append([]int(nil), test.widths...)
+		fitColumnWidths(widths, test.maxWidth)
+
+		if !reflect.DeepEqual(widths, test.want) {
+			t.Errorf("fitColumnWidths(%v, %d) = %v, want %v", test.widths, test.maxWidth, widths, test.want)
+		}
+	}
+}
+
+func TestWrapCell(t *testing.T) {
+	tests := []struct {
+		cell  string
+		width int
+		want  []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"exactly", 7, []string{"exactly"}},
+		{"hello world foo", 11, []string{"hello world", "foo"}},
+		{"hello world foo", 8, []string{"hello", "world", "foo"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"aa bbbbbbbbbb", 5, []string{"aa", "bbbbb", "bbbbb"}},
+		{"héllo wörld", 5, []string{"héllo", "wörld"}},
+		{"日本語のテキスト", 3, []string{"日本語", "のテキ", "スト"}},
+	}
+
+	for _, test := range tests {
+		got := wrapCell(test.cell, test.width)
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("wrapCell(%q, %d) = %q, want %q", test.cell, test.width, got, test.want)
+		}
+	}
+}
+
+func TestTruncateToWidth(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello world", 8, "hello..."},
+		{"héllo wörld", 8, "héllo..."},
+		{"日本語のテキスト", 6, "日本語..."},
+		{"abcdef", 3, "abcdef"},
+	}
+
+	for _, test := range tests {
+		got := truncateToWidth(test.s, test.width)
+
+		if got != test.want {
+			t.Errorf("truncateToWidth(%q, %d) = %q, want %q", test.s, test.width, got, test.want)
+		}
+	}
+}
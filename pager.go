@@ -0,0 +1,70 @@
+package clicommon
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"golang.org/x/term"
+)
+
+const defaultPager = "less -FRX"
+
+// Page renders output with writer and shows it through the user's $PAGER
+// (defaulting to "less -FRX") if stdout is a terminal and the output is taller
+// than the screen. Otherwise, or if the pager can't be started, the output is
+// written directly to stdout.
+func Page(writer func(io.Writer)) error {
+	if !isatty.IsTerminal(os.Stdout.Fd()) {
+		writer(os.Stdout)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	writer(&buf)
+
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || countScreenLines(buf.Bytes(), width) < height {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = strings.Fields(defaultPager)
+	}
+
+	pagerPath, err := exec.LookPath(pager[0])
+	if err != nil {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+
+	cmd := exec.Command(pagerPath, pager[1:]...)
+	cmd.Stdin = &buf
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// countScreenLines counts how many terminal lines text takes up once long lines
+// are wrapped at width
+func countScreenLines(text []byte, width int) int {
+	lines := 0
+
+	for _, line := range bytes.Split(bytes.TrimSuffix(text, []byte("\n")), []byte("\n")) {
+		length := len([]rune(string(line)))
+
+		if width <= 0 || length <= width {
+			lines++
+		} else {
+			lines += (length + width - 1) / width
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,358 @@
+package clicommon
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattn/go-isatty"
+	"golang.org/x/term"
+)
+
+const progressRedrawInterval = 100 * time.Millisecond
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// MultiProgress displays the progress of several concurrently running tasks.
+// On a terminal every running task gets its own continuously redrawn row and
+// finished tasks are collapsed into a summary row, otherwise each task update
+// is printed as a plain line in the order it happened. All methods are safe to
+// call from multiple goroutines.
+type MultiProgress struct {
+	mu         sync.Mutex
+	isTTY      bool
+	tasks      []*ProgressTask
+	drawnLines int
+	frame      int
+	finished   bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// ProgressTask is a single row in a MultiProgress display
+type ProgressTask struct {
+	progress *MultiProgress
+
+	name    string
+	status  string
+	current int
+	total   int
+	started time.Time
+	ended   time.Time
+	done    bool
+	err     error
+}
+
+// NewMultiProgress creates a new progress display and, if stdout is a
+// terminal, starts redrawing it in the background. Finish must be called once
+// all tasks are done to stop the redrawing and print the final summary.
+func NewMultiProgress() *MultiProgress {
+	p := &MultiProgress{
+		isTTY:   isatty.IsTerminal(os.Stdout.Fd()),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if p.isTTY {
+		hideCursor()
+		go p.run()
+	} else {
+		close(p.stopped)
+	}
+
+	return p
+}
+
+// AddTask adds a new running task to the display. If total is greater than 0
+// the task is drawn as a progress bar, otherwise it gets a spinner.
+func (p *MultiProgress) AddTask(name string, total int) *ProgressTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	task := &ProgressTask{
+		progress: p,
+		name:     name,
+		total:    total,
+		started:  time.Now(),
+	}
+
+	p.tasks = append(p.tasks, task)
+
+	if !p.isTTY {
+		fmt.Printf("[%s] started\n", name)
+	}
+
+	return task
+}
+
+// Finish stops redrawing the display and prints how many tasks succeeded and
+// failed, and how many are still running as unfinished. The errors of failed
+// tasks aren't repeated, since they're printed when each task fails, and can be
+// gotten with Failed.
+func (p *MultiProgress) Finish() {
+	p.mu.Lock()
+	if p.finished {
+		p.mu.Unlock()
+		return
+	}
+	p.finished = true
+	p.mu.Unlock()
+
+	if p.isTTY {
+		close(p.stop)
+	}
+	<-p.stopped
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.isTTY {
+		p.clear()
+		showCursor()
+	}
+
+	succeeded, failed, running := p.counts()
+
+	fmt.Printf("%d succeeded, %d failed", succeeded, failed)
+	if running > 0 {
+		fmt.Printf(", %d unfinished", running)
+	}
+	fmt.Println()
+}
+
+// Failed returns the tasks that finished with an error
+func (p *MultiProgress) Failed() []*ProgressTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var failed []*ProgressTask
+
+	for _, task := range p.tasks {
+		if task.done && task.err != nil {
+			failed = append(failed, task)
+		}
+	}
+
+	return failed
+}
+
+func (p *MultiProgress) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(progressRedrawInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+
+		case <-ticker.C:
+			p.mu.Lock()
+			p.frame++
+			p.redraw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// redraw replaces the previously drawn rows with the current state of all
+// running tasks and the summary row. The caller must hold the lock.
+func (p *MultiProgress) redraw() {
+	p.clear()
+
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+
+	nameWidth := 0
+	for _, task := range p.tasks {
+		if width := utf8.RuneCountInString(task.name); !task.done && width > nameWidth {
+			nameWidth = width
+		}
+	}
+
+	for _, task := range p.tasks {
+		if task.done {
+			continue
+		}
+
+		fmt.Println(truncateToWidth(p.formatRow(task, nameWidth), width))
+		p.drawnLines++
+	}
+
+	succeeded, failed, running := p.counts()
+	summary := fmt.Sprintf("%d/%d done", succeeded+failed, succeeded+failed+running)
+	if failed > 0 {
+		summary += fmt.Sprintf(", %d failed", failed)
+	}
+
+	fmt.Println(truncateToWidth(summary, width))
+	p.drawnLines++
+}
+
+// clear erases all previously drawn rows and leaves the cursor where the first
+// row was. The caller must hold the lock.
+func (p *MultiProgress) clear() {
+	if p.drawnLines > 0 {
+		prevLine(p.drawnLines)
+	} else {
+		startOfLine()
+	}
+
+	eraseRemaining()
+	p.drawnLines = 0
+}
+
+func (p *MultiProgress) formatRow(task *ProgressTask, nameWidth int) string {
+	var indicator string
+
+	if task.total > 0 {
+		indicator = progressBar(task.current, task.total, 20)
+	} else {
+		indicator = spinnerFrames[p.frame%len(spinnerFrames)]
+	}
+
+	row := fmt.Sprintf("  %s %-*s  %s", indicator, nameWidth, task.name, formatElapsed(time.Since(task.started)))
+
+	if task.status != "" {
+		row += "  " + task.status
+	}
+
+	return row
+}
+
+func (p *MultiProgress) counts() (succeeded, failed, running int) {
+	for _, task := range p.tasks {
+		switch {
+		case !task.done:
+			running++
+		case task.err != nil:
+			failed++
+		default:
+			succeeded++
+		}
+	}
+
+	return
+}
+
+// Name gets the name the task was added with
+func (t *ProgressTask) Name() string {
+	return t.name
+}
+
+// Err gets the error the task failed with, if any
+func (t *ProgressTask) Err() error {
+	t.progress.mu.Lock()
+	defer t.progress.mu.Unlock()
+
+	return t.err
+}
+
+// SetStatus sets a short status message shown next to the task
+func (t *ProgressTask) SetStatus(status string) {
+	p := t.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t.done || t.status == status {
+		return
+	}
+
+	t.status = status
+
+	if !p.isTTY {
+		fmt.Printf("[%s] %s\n", t.name, status)
+	}
+}
+
+// SetCurrent sets how much of the task's total has been completed
+func (t *ProgressTask) SetCurrent(current int) {
+	p := t.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.current = current
+}
+
+// Increment adds one to how much of the task's total has been completed
+func (t *ProgressTask) Increment() {
+	p := t.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.current++
+}
+
+// Done marks the task as finished. If err is not nil the task is marked as
+// failed and its error is printed, otherwise it's collapsed into the summary.
+func (t *ProgressTask) Done(err error) {
+	p := t.progress
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t.done {
+		return
+	}
+
+	t.done = true
+	t.err = err
+	t.ended = time.Now()
+
+	elapsed := formatElapsed(t.ended.Sub(t.started))
+
+	if p.isTTY && !p.finished {
+		if err != nil {
+			// Print failures above the redrawn rows so they aren't lost once
+			// the task disappears from the display
+			p.clear()
+			fmt.Printf("  x %s failed after %s: %s\n", t.name, elapsed, err)
+			p.redraw()
+		}
+	} else if err != nil {
+		fmt.Printf("[%s] failed after %s: %s\n", t.name, elapsed, err)
+	} else {
+		fmt.Printf("[%s] done in %s\n", t.name, elapsed)
+	}
+}
+
+func progressBar(current, total, width int) string {
+	if current > total {
+		current = total
+	} else if current < 0 {
+		current = 0
+	}
+
+	filled := current * width / total
+
+	return fmt.Sprintf(
+		"[%s%s] %3d%%",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", width-filled),
+		current*100/total,
+	)
+}
+
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+
+	return d.Round(time.Second).String()
+}
+
+// truncateToWidth shortens s to width characters, cutting between characters
+// rather than bytes
+func truncateToWidth(s string, width int) string {
+	if width < 4 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+
+	return string([]rune(s)[:width-3]) + "..."
+}
@@ -0,0 +1,82 @@
+package clicommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/kardianos/osext"
+)
+
+// RequireRoot makes sure the whole command runs with superuser permissions. If
+// the process doesn't have them yet, the user is asked for them, and this
+// program is run again with the same arguments through the escalator, with
+// stdin, stdout and stderr connected to it. This process then exits with the
+// same exit code instead of returning. It can be called at the beginning of
+// main(), after TryHandleSudo, or from a single command that needs it once its
+// arguments are parsed.
+//
+// Escalators that can't connect to the elevated process's stdin and stdout,
+// like a UAC prompt, run it in a new console window.
+func RequireRoot() error {
+	escalator, err := DetectEscalator()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := escalator.(alreadyElevatedEscalator); ok {
+		return nil
+	}
+
+	// Escalating again from a process an escalator started without superuser
+	// permissions would only start the same process again
+	if startedByEscalator() {
+		return errors.New("not running with superuser permissions, even though this program was started through an escalator")
+	}
+
+	err = confirmSudo([]string{
+		fmt.Sprintf("Run %s", strings.Join(os.Args, " ")),
+	})
+	if err != nil {
+		return err
+	}
+
+	thisExe, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+
+	request := &EscalationRequest{
+		Executable: thisExe,
+		Args:       os.Args[1:],
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+	}
+
+	// Signals sent to this process are forwarded to the escalator, which
+	// passes them on to the elevated process, and this process keeps waiting
+	// for it to exit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	request.Signals = signals
+
+	Log.Debug("Re-executing with superuser permissions", "escalator", escalator.Name())
+
+	err = escalator.Run(context.Background(), request)
+	if err == nil {
+		os.Exit(0)
+	}
+
+	if code, ok := escalationExitCode(err); ok {
+		os.Exit(code)
+	}
+
+	return err
+}
@@ -1,12 +1,31 @@
 package clicommon
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/kardianos/osext"
 )
 
-const sudoArg = "__sudo"
+const (
+	sudoArg = "__sudo"
+
+	// sudoProtocolVersion changes whenever the way sudo calls are passed to
+	// the elevated process changes, so an elevated process from a different
+	// version of this program doesn't misinterpret them
+	sudoProtocolVersion = 3
+
+	// sudoAskpassEnv is set when sudo runs this program as its askpass program
+	sudoAskpassEnv = "CLICOMMON_SUDO_ASKPASS"
+)
 
 type SudoAction interface {
 	Name() string
@@ -14,42 +33,571 @@ type SudoAction interface {
 	Handle(params []string) error
 }
 
-var registeredActions = map[string]SudoAction{}
+// AnySudoAction is either a SudoAction or a TypedSudoAction, for functions that
+// can run both
+type AnySudoAction interface {
+	Name() string
+}
 
-func RegisterAction(action SudoAction) {
-	registeredActions[action.Name()] = action
+// TypedSudoAction is a sudo action whose exported fields are its parameters.
+// It's sent to the elevated process as JSON, decoded into a new value of the
+// same type, and run there. Whatever Run returns is sent back as JSON and
+// decoded by CallSudoTyped.
+type TypedSudoAction interface {
+	Name() string
+	Run() (interface{}, error)
 }
 
 // CallSudo asks the user for superuser permissions, and then executes the
 // currently-running program with those permissions for a particular action.
 // TryHandleSudo should be called at the beginning of the program's main()
-// function to catch these sudo calls.
+// function to catch these sudo calls. If the action fails, its error is
+// returned as a *SudoError.
+//
+// The action's params are passed to the elevated process through a private
+// temporary file rather than its command line, so they aren't visible to other
+// users. That file is signed with a one-time key which is sent to the elevated
+// process separately, so it doesn't pick up a stale or mixed-up payload. This
+// doesn't prove the action was requested through CallSudo though: anyone who
+// may run this program with sudo can write their own payload and key, and run
+// any registered action with any params. Actions must be safe to run for
+// everyone who may do that.
+//
+// Before asking for superuser permissions the user is shown what they're for,
+// using the action's Describe method if it has one, and can decline. Every
+// action is recorded in the audit log if one was opened with OpenSudoAuditLog,
+// and only printed instead of run in dry-run mode.
 func CallSudo(action SudoAction) error {
-	return callSudo(action.Name(), action.Params())
+	return CallSudoContext(context.Background(), action, nil, SudoCallOptions{})
+}
+
+// CallSudoTyped runs a TypedSudoAction with superuser permissions like
+// CallSudo, and decodes the action's result into result, which should be a
+// pointer to the type the action returns, or nil to ignore the result
+func CallSudoTyped(action TypedSudoAction, result interface{}) error {
+	return CallSudoContext(context.Background(), action, result, SudoCallOptions{})
+}
+
+// SudoCallOptions configures CallSudoContext
+type SudoCallOptions struct {
+	// Stdin is passed on to the action, usually os.Stdin. It has to be a file
+	// so that reading from it can stop as soon as the action exits.
+	Stdin *os.File
+
+	// Timeout stops the action if it takes longer, like canceling ctx
+	Timeout time.Duration
+
+	// User runs the action as this user instead of root, see CallSudoAs
+	User string
+}
+
+// CallSudoContext runs a SudoAction or TypedSudoAction with superuser
+// permissions like CallSudo, decoding a typed action's result into result
+// unless it's nil. SIGINT and SIGTERM received while the action runs are
+// passed on to it. When ctx is done or the timeout expires, the action is asked
+// to stop and killed if it doesn't, and an error wrapping ctx's is returned.
+// If the elevated process exits without a response, e.g. because the action
+// called os.Exit, a *SudoExitError with its exit code is returned.
+func CallSudoContext(ctx context.Context, action AnySudoAction, result interface{}, options SudoCallOptions) error {
+	response, err := callSudoAction(ctx, action, options)
+	if err != nil {
+		return err
+	}
+
+	if result != nil && len(response.Results) > 0 {
+		return json.Unmarshal(response.Results[0], result)
+	}
+
+	return nil
+}
+
+func callSudoAction(ctx context.Context, action AnySudoAction, options SudoCallOptions) (*sudoResponse, error) {
+	step, err := encodeSudoStep(action)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := callSudoPayload(ctx, &sudoPayload{
+		Action: action.Name(),
+		Steps:  []*sudoStep{step},
+		User:   options.User,
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Error != nil {
+		return nil, response.Error
+	}
+
+	return response, nil
+}
+
+// callSudoPayload sends a payload to a new elevated process, waits for it to
+// exit, and returns its response
+func callSudoPayload(ctx context.Context, payload *sudoPayload, options SudoCallOptions) (*sudoResponse, error) {
+	Log.Debug("Calling sudo action", "action", payload.Action, "steps", len(payload.Steps))
+
+	if payload.User != "" {
+		err := checkRunAsUser(payload.User)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if IsSudoDryRun() {
+		printSudoDryRun(payload.Steps, payload.User)
+		return &sudoResponse{}, nil
+	}
+
+	descriptions := make([]string, len(payload.Steps))
+	for i, step := range payload.Steps {
+		descriptions[i] = step.description
+
+		if payload.User != "" {
+			descriptions[i] += " (as " + payload.User + ")"
+		}
+	}
+
+	err := confirmSudo(descriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	key, err := newSudoKey()
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := writeSudoPayload(payload, key)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(handle)
+
+	err = callSudo(ctx, payload.Action, handle, key, options)
+
+	response, responseErr := readSudoResponse(handle, key)
+	if responseErr != nil {
+		auditSudoSteps(payload, nil, responseErr)
+		recordSudoSteps(payload, nil, responseErr)
+		return nil, responseErr
+	}
+
+	auditSudoSteps(payload, response, err)
+	recordSudoSteps(payload, response, err)
+
+	if response == nil {
+		if code, ok := escalationExitCode(err); ok {
+			return nil, &SudoExitError{Code: code, Err: err}
+		} else if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New("sudo action finished without a response")
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil && response.Error != nil {
+		return nil, fmt.Errorf("sudo action stopped: %w (%v)", ctxErr, response.Error)
+	}
+
+	return response, nil
+}
+
+// recordSudoSteps tells the escalator how every step of a payload turned out,
+// if it keeps track of that. The response is nil if the elevated process never
+// sent one, in which case err is why.
+func recordSudoSteps(payload *sudoPayload, response *sudoResponse, err error) {
+	escalator, detectErr := DetectEscalator()
+	if detectErr != nil {
+		return
+	}
+
+	recorder, ok := escalator.(SudoCallRecorder)
+	if !ok {
+		return
+	}
+
+	records := make([]SudoCallRecord, len(payload.Steps))
+
+	for i, step := range payload.Steps {
+		records[i] = SudoCallRecord{
+			Action: step.Action,
+			Params: step.Params,
+			Data:   step.Data,
+			User:   payload.User,
+		}
+
+		switch {
+		case response == nil:
+			records[i].Err = err
+
+		case response.Error == nil || i < response.FailedStep:
+			records[i].Ran = true
+
+			if i < len(response.Results) {
+				records[i].Result = response.Results[i]
+			}
+
+		case i == response.FailedStep:
+			records[i].Ran = true
+			records[i].Err = response.Error
+		}
+	}
+
+	recorder.RecordSudoCalls(records)
+}
+
+// encodeSudoStep converts an action into the form it's sent to the elevated
+// process in, including its compensating action if it has one
+func encodeSudoStep(action AnySudoAction) (*sudoStep, error) {
+	step := &sudoStep{
+		Action: action.Name(),
+	}
+
+	switch action := action.(type) {
+	case TypedSudoAction:
+		data, err := json.Marshal(action)
+		if err != nil {
+			return nil, err
+		}
+
+		step.Data = data
+
+	case SudoAction:
+		step.Params = action.Params()
+
+	default:
+		return nil, fmt.Errorf("%T is not a SudoAction or TypedSudoAction", action)
+	}
+
+	step.description = describeSudoAction(action, step)
+
+	if compensated, ok := action.(CompensatedSudoAction); ok {
+		if compensation := compensated.Compensation(); compensation != nil {
+			var err error
+
+			step.Compensation, err = encodeSudoStep(compensation)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return step, nil
+}
+
+// callSudo runs this program again through an escalator to handle the action
+// behind the handle
+func callSudo(ctx context.Context, action, handle string, key []byte, options SudoCallOptions) error {
+	escalator, err := DetectEscalator()
+	if err != nil {
+		return err
+	}
+
+	thisExe, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+
+	request := &EscalationRequest{
+		Executable: thisExe,
+		Args:       sudoArgs(action, handle),
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		User:       options.User,
+	}
+
+	err = sendSudoKey(request, handle, key)
+	if err != nil {
+		return err
+	}
+
+	if options.Stdin != nil {
+		started := func() bool {
+			return sudoStarted(handle, key)
+		}
+
+		stop, err := forwardSudoStdin(request, options.Stdin, started)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	request.Signals = signals
+
+	Log.Debug("Escalating privileges", "escalator", escalator.Name())
+
+	return escalator.Run(ctx, request)
 }
 
 // TryHandleSudo catches superuser self-executions to do certain actions that
-// require superuser permissions
+// require superuser permissions, exiting once the action is done. Use
+// HandleSudo instead to exit some other way.
 func TryHandleSudo() {
-	if len(os.Args) >= 3 && os.Args[1] == sudoArg {
-		action := os.Args[2]
-		params := os.Args[3:]
+	handled, response, err := handleSudoArgs(os.Args)
+	if !handled {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error handling sudo action")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if response != nil && response.Error != nil {
+		os.Exit(1)
+	}
 
-		err := handleSudo(action, params)
+	os.Exit(0)
+}
+
+// HandleSudo is like TryHandleSudo, but returns instead of exiting, so
+// deferred functions still run and command frameworks can exit however they
+// need to. If handled is true the program was run to handle a sudo call and
+// should exit right away, with a non-zero exit code if err isn't nil. An
+// action's error is sent back to the caller as well as returned.
+//
+// Sudo calls from a different version of this program, e.g. one that updated
+// itself in the meantime, fail with an error wrapping ErrSudoProtocolMismatch.
+func HandleSudo(args []string) (handled bool, err error) {
+	handled, response, err := handleSudoArgs(args)
+	if err != nil {
+		return handled, err
+	}
+
+	if response != nil && response.Error != nil {
+		return handled, response.Error
+	}
+
+	return handled, nil
+}
+
+// handleSudoArgs handles a sudo call or askpass request if args are for one,
+// returning the response to a sudo call after it was written for the caller
+func handleSudoArgs(args []string) (bool, *sudoResponse, error) {
+	// sudo runs its askpass program as the invoking user, with the prompt as
+	// its only argument
+	if os.Getenv(sudoAskpassEnv) != "" && os.Geteuid() != 0 && len(args) <= 2 {
+		var prompt string
+		if len(args) == 2 {
+			prompt = args[1]
+		}
+
+		err := runSudoAskpass(prompt)
 		if err != nil {
-			fmt.Println("Error handling sudo action")
-			fmt.Println(err)
-			os.Exit(1)
+			return true, nil, fmt.Errorf("asking for password: %w", err)
 		}
 
-		os.Exit(0)
+		return true, nil, nil
 	}
+
+	if len(args) < 2 || args[1] != sudoArg {
+		return false, nil, nil
+	}
+
+	// Older versions passed the action right after the sudo argument, and
+	// its params after that instead of a handle
+	version := 1
+	if len(args) > 2 {
+		if parsed, err := strconv.Atoi(args[2]); err == nil {
+			version = parsed
+		}
+	}
+
+	if version != sudoProtocolVersion || len(args) != 5 {
+		return true, nil, fmt.Errorf("%w: called with version %d, expected version %d", ErrSudoProtocolMismatch, version, sudoProtocolVersion)
+	}
+
+	action := args[3]
+	handle := args[4]
+
+	response, err := handleSudo(action, handle)
+	if err != nil {
+		return true, nil, err
+	}
+
+	return true, response, nil
+}
+
+// sudoArgs builds the arguments this program is run with to handle a sudo call
+func sudoArgs(action, handle string) []string {
+	return []string{sudoArg, strconv.Itoa(sudoProtocolVersion), action, handle}
 }
 
-func handleSudo(action string, params []string) error {
-	if handler, ok := registeredActions[action]; ok {
-		return handler.Handle(params)
+// CheckOwnedByInvokingUser returns an error if the file at path, without
+// following symlinks, isn't owned by the user that requested the running sudo
+// action. Actions should check any files they're given this way, so they can't
+// be used to act on files the requesting user had no control over.
+func CheckOwnedByInvokingUser(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	return checkOwnedByInvoker(info)
+}
+
+// handleSudo verifies and runs the action behind a handle, and writes its
+// response for the caller. An error is only returned if the handle itself is
+// invalid, since there's nowhere to send a response then, otherwise the
+// action's error is part of the response.
+func handleSudo(action string, handle string) (*sudoResponse, error) {
+	shared, err := checkSudoHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := readSudoKey(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := readSudoPayload(handle, action, key, shared)
+	if err != nil {
+		return nil, err
+	}
+
+	err = markSudoStarted(handle, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var response *sudoResponse
+
+	if err := checkRunningAsUser(payload.User); err != nil {
+		response = &sudoResponse{Error: toSudoError(err)}
+	} else if payload.Action == sudoHelperActionName {
+		response = &sudoResponse{Error: toSudoError(serveSudoHelper(payload))}
+	} else {
+		response = runSudoSteps(payload)
+	}
+
+	// A sudo helper's steps are audited one at a time as they run
+	if payload.Action != sudoHelperActionName {
+		auditElevatedSudoSteps(payload, response)
+	}
+
+	err = writeSudoResponse(handle, key, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// runSudoSteps runs every step in order until one fails, and then runs the
+// compensating actions of the completed steps in reverse if requested
+func runSudoSteps(payload *sudoPayload) *sudoResponse {
+	response := &sudoResponse{}
+
+	for i, step := range payload.Steps {
+		result, err := runSudoStep(step)
+		if err == nil {
+			var encoded []byte
+
+			encoded, err = json.Marshal(result)
+			response.Results = append(response.Results, encoded)
+		}
+
+		if err != nil {
+			response.Error = toSudoError(err)
+			response.FailedStep = i
+
+			if payload.Rollback {
+				response.RollbackErrors = rollbackSudoSteps(payload.Steps[:i])
+			}
+
+			break
+		}
+	}
+
+	return response
+}
+
+func rollbackSudoSteps(completed []*sudoStep) []*SudoError {
+	var errs []*SudoError
+
+	for i := len(completed) - 1; i >= 0; i-- {
+		compensation := completed[i].Compensation
+		if compensation == nil {
+			continue
+		}
+
+		_, err := runSudoStep(compensation)
+		if err != nil {
+			errs = append(errs, toSudoError(fmt.Errorf("rolling back %s: %w", completed[i].Action, err)))
+		}
+	}
+
+	return errs
+}
+
+func runSudoStep(step *sudoStep) (interface{}, error) {
+	if step == nil {
+		return nil, errors.New("missing sudo action")
+	}
+
+	handler, actionType := lookupAction(step.Action)
+
+	if actionType != nil {
+		action, err := decodeTypedAction(actionType, step.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		err = validateTypedSudoParams(action)
+		if err != nil {
+			return nil, err
+		}
+
+		return action.Run()
+	}
+
+	if handler != nil {
+		err := validateSudoParams(handler, step.Params)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, handler.Handle(step.Params)
+	}
+
+	return nil, fmt.Errorf("unknown sudo action '%s'", step.Action)
+}
+
+// decodeTypedAction decodes data into a new value of a registered action's
+// type, which may either be a struct or a pointer to one
+func decodeTypedAction(actionType reflect.Type, data []byte) (TypedSudoAction, error) {
+	var target, action reflect.Value
+
+	if actionType.Kind() == reflect.Ptr {
+		target = reflect.New(actionType.Elem())
+		action = target
+	} else {
+		target = reflect.New(actionType)
+		action = target.Elem()
+	}
+
+	if len(data) > 0 {
+		err := json.Unmarshal(data, target.Interface())
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return errors.New("unknown sudo action")
+	return action.Interface().(TypedSudoAction), nil
 }
@@ -0,0 +1,34 @@
+package clicommon
+
+import (
+	"fmt"
+	"os"
+)
+
+// ChmodSudoAction changes the mode of the file at Path
+type ChmodSudoAction struct {
+	Path string
+	Mode os.FileMode
+}
+
+func (a ChmodSudoAction) Name() string {
+	return ActionName(standardActionNamespace, "chmod")
+}
+
+func (a ChmodSudoAction) Describe() string {
+	return fmt.Sprintf("Change the mode of %s to %04o", a.Path, a.Mode)
+}
+
+func (a ChmodSudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:     "Path",
+			Type:     SudoParamPath,
+			Required: true,
+		},
+	}
+}
+
+func (a ChmodSudoAction) Run() (interface{}, error) {
+	return nil, os.Chmod(a.Path, a.Mode)
+}
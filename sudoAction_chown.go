@@ -0,0 +1,36 @@
+package clicommon
+
+import (
+	"fmt"
+	"os"
+)
+
+// ChownSudoAction changes the owner of the file at Path, or of the symlink
+// itself if Path is one. An ID of -1 keeps that part of the owner unchanged.
+type ChownSudoAction struct {
+	Path string
+	UID  int
+	GID  int
+}
+
+func (a ChownSudoAction) Name() string {
+	return ActionName(standardActionNamespace, "chown")
+}
+
+func (a ChownSudoAction) Describe() string {
+	return fmt.Sprintf("Change the owner of %s to %d:%d", a.Path, a.UID, a.GID)
+}
+
+func (a ChownSudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:     "Path",
+			Type:     SudoParamPath,
+			Required: true,
+		},
+	}
+}
+
+func (a ChownSudoAction) Run() (interface{}, error) {
+	return nil, os.Lchown(a.Path, a.UID, a.GID)
+}
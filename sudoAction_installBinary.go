@@ -0,0 +1,80 @@
+package clicommon
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+const defaultBinaryInstallDir = "/usr/local/bin"
+
+// InstallBinarySudoAction copies the executable at Source into a directory on
+// the system PATH, owned by root and executable by everyone. Source must be a
+// regular file owned by the invoking user.
+type InstallBinarySudoAction struct {
+	Source string
+
+	// Dir defaults to /usr/local/bin, and must be set on Windows
+	Dir string
+
+	// InstallName defaults to the file name of Source
+	InstallName string
+}
+
+func (a InstallBinarySudoAction) Name() string {
+	return ActionName(standardActionNamespace, "installBinary")
+}
+
+func (a InstallBinarySudoAction) Describe() string {
+	dir := a.Dir
+	if dir == "" {
+		dir = defaultBinaryInstallDir
+	}
+
+	return fmt.Sprintf("Install %s into %s", a.Source, dir)
+}
+
+func (a InstallBinarySudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:           "Source",
+			Type:           SudoParamPath,
+			Required:       true,
+			RegularFile:    true,
+			OwnedByInvoker: true,
+		},
+		{
+			Name: "Dir",
+			Type: SudoParamPath,
+		},
+	}
+}
+
+func (a InstallBinarySudoAction) Run() (interface{}, error) {
+	dir := a.Dir
+	if dir == "" {
+		if runtime.GOOS == "windows" {
+			return nil, errors.New("install directory is required on Windows")
+		}
+
+		dir = defaultBinaryInstallDir
+	}
+
+	name := a.InstallName
+	if name == "" {
+		name = filepath.Base(a.Source)
+	}
+
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return nil, errors.New("invalid binary install name")
+	}
+
+	source, err := OpenInvokingUserFile(a.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	return nil, writeFileAtomic(filepath.Join(dir, name), source, binaryFilePermissions, 0, 0)
+}
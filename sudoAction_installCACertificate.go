@@ -0,0 +1,98 @@
+package clicommon
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+)
+
+var caCertificateNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// caTrustStore is a Linux distribution's directory for extra trusted CA
+// certificates, along with the command that rebuilds the trust store from it
+type caTrustStore struct {
+	dir     string
+	command []string
+}
+
+var caTrustStores = []caTrustStore{
+	// Debian, Ubuntu, Alpine
+	{dir: "/usr/local/share/ca-certificates", command: []string{"update-ca-certificates"}},
+	// Fedora, RHEL, CentOS
+	{dir: "/etc/pki/ca-trust/source/anchors", command: []string{"update-ca-trust", "extract"}},
+	// Arch
+	{dir: "/etc/ca-certificates/trust-source/anchors", command: []string{"trust", "extract-compat"}},
+	// openSUSE
+	{dir: "/usr/share/pki/trust/anchors", command: []string{"update-ca-certificates"}},
+}
+
+// InstallCACertificateSudoAction adds a PEM-encoded CA certificate to the Linux
+// system trust store, as <CertName>.crt in the distribution's directory for
+// extra certificates, and then rebuilds the trust store
+type InstallCACertificateSudoAction struct {
+	CertName string
+	PEM      []byte
+}
+
+func (a InstallCACertificateSudoAction) Name() string {
+	return ActionName(standardActionNamespace, "installCACertificate")
+}
+
+func (a InstallCACertificateSudoAction) Describe() string {
+	return fmt.Sprintf("Trust the CA certificate %s system-wide", a.CertName)
+}
+
+func (a InstallCACertificateSudoAction) Run() (interface{}, error) {
+	if runtime.GOOS != "linux" {
+		return nil, errors.New("installing CA certificates is only supported on Linux")
+	}
+
+	if !caCertificateNameRegex.MatchString(a.CertName) {
+		return nil, errors.New("invalid CA certificate name")
+	}
+
+	block, rest := pem.Decode(a.PEM)
+	if block == nil || block.Type != "CERTIFICATE" || len(bytes.TrimSpace(rest)) > 0 {
+		return nil, errors.New("expected a single PEM-encoded certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cert.IsCA {
+		return nil, errors.New("certificate is not a CA certificate")
+	}
+
+	for _, store := range caTrustStores {
+		if info, err := os.Stat(store.dir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		err = writeFileAtomic(
+			filepath.Join(store.dir, a.CertName+".crt"),
+			bytes.NewReader(pem.EncodeToMemory(block)),
+			defaultFilePermissions,
+			0, 0,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd := exec.Command(store.command[0], store.command[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		return nil, cmd.Run()
+	}
+
+	return nil, errors.New("no known system trust store directory found")
+}
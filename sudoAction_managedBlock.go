@@ -0,0 +1,170 @@
+package clicommon
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultManagedBlockCommentPrefix = "#"
+
+// ManagedBlockSudoAction adds, replaces or removes a block of lines in a system
+// file like /etc/hosts. The block is wrapped in "BEGIN <Marker>" and
+// "END <Marker>" comment lines so it can be found again later, and the rest of
+// the file is left untouched. The file keeps its mode, owner and line endings,
+// and is created if it doesn't exist yet. If Path is a symlink, the file it
+// points to is updated.
+type ManagedBlockSudoAction struct {
+	Path   string
+	Marker string
+
+	// Content is the block's lines, without the marker comments
+	Content string
+
+	// Remove removes the block instead of adding or replacing it
+	Remove bool
+
+	// CommentPrefix starts the marker lines, defaulting to "#"
+	CommentPrefix string
+}
+
+func (a ManagedBlockSudoAction) Name() string {
+	return ActionName(standardActionNamespace, "managedBlock")
+}
+
+func (a ManagedBlockSudoAction) Describe() string {
+	if a.Remove {
+		return fmt.Sprintf("Remove the %s block from %s", a.Marker, a.Path)
+	}
+
+	return fmt.Sprintf("Update the %s block in %s", a.Marker, a.Path)
+}
+
+func (a ManagedBlockSudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:     "Path",
+			Type:     SudoParamPath,
+			Required: true,
+		},
+	}
+}
+
+func (a ManagedBlockSudoAction) Run() (interface{}, error) {
+	if strings.TrimSpace(a.Marker) == "" || strings.ContainsAny(a.Marker, "\r\n") {
+		return nil, errors.New("invalid managed block marker")
+	}
+
+	prefix := a.CommentPrefix
+	if prefix == "" {
+		prefix = defaultManagedBlockCommentPrefix
+	}
+
+	begin := prefix + " BEGIN " + a.Marker
+	end := prefix + " END " + a.Marker
+
+	// The file a symlink like /etc/resolv.conf points to is updated, instead
+	// of replacing the symlink with a copy of it
+	path := a.Path
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		path, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mode := os.FileMode(defaultFilePermissions)
+	uid, gid := -1, -1
+
+	text, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if a.Remove {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		mode = info.Mode().Perm()
+		uid, gid = fileOwner(info)
+	}
+
+	updated, err := updateManagedBlock(string(text), begin, end, a.Content, a.Remove)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, writeFileAtomic(path, strings.NewReader(updated), mode, uid, gid)
+}
+
+// updateManagedBlock replaces the lines from begin to end in text with content
+// wrapped in those markers, or appends them if text has no such block. If remove
+// is set, the block is removed instead. The block uses the same line endings as
+// the rest of text.
+func updateManagedBlock(text, begin, end, content string, remove bool) (string, error) {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	newline := "\n"
+	if len(lines) > 0 && strings.HasSuffix(lines[0], "\r\n") {
+		newline = "\r\n"
+	}
+
+	var block []string
+	if !remove {
+		block = append(block, begin+newline)
+
+		content = strings.ReplaceAll(content, "\r\n", "\n")
+
+		for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+			block = append(block, line+newline)
+		}
+
+		block = append(block, end+newline)
+	}
+
+	start, stop := -1, -1
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == begin && start == -1 {
+			start = i
+		} else if trimmed == end && start != -1 {
+			stop = i
+			break
+		}
+	}
+
+	var updated []string
+
+	if start != -1 && stop != -1 {
+		updated = append(updated, lines[:start]...)
+		updated = append(updated, block...)
+		updated = append(updated, lines[stop+1:]...)
+	} else if start != -1 {
+		return "", errors.New("managed block has no end marker")
+	} else if remove {
+		return text, nil
+	} else {
+		updated = lines
+
+		if len(updated) > 0 && !strings.HasSuffix(updated[len(updated)-1], "\n") {
+			updated[len(updated)-1] += newline
+		}
+
+		updated = append(updated, block...)
+	}
+
+	return strings.Join(updated, ""), nil
+}
@@ -0,0 +1,197 @@
+package clicommon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestUpdateManagedBlock(t *testing.T) {
+	const (
+		begin = "# BEGIN app"
+		end   = "# END app"
+	)
+
+	tests := []struct {
+		name    string
+		text    string
+		content string
+		remove  bool
+		want    string
+	}{
+		{
+			name:    "empty file",
+			text:    "",
+			content: "127.0.0.1 app.local",
+			want:    "# BEGIN app\n127.0.0.1 app.local\n# END app\n",
+		},
+		{
+			name:    "appended",
+			text:    "127.0.0.1 localhost\n",
+			content: "127.0.0.1 app.local",
+			want:    "127.0.0.1 localhost\n# BEGIN app\n127.0.0.1 app.local\n# END app\n",
+		},
+		{
+			name:    "no trailing newline",
+			text:    "127.0.0.1 localhost",
+			content: "127.0.0.1 app.local",
+			want:    "127.0.0.1 localhost\n# BEGIN app\n127.0.0.1 app.local\n# END app\n",
+		},
+		{
+			name:    "replaced",
+			text:    "a\n# BEGIN app\nold\nlines\n# END app\nb\n",
+			content: "new",
+			want:    "a\n# BEGIN app\nnew\n# END app\nb\n",
+		},
+		{
+			name:    "replaced with indented markers",
+			text:    "a\n  # BEGIN app  \nold\n\t# END app\nb\n",
+			content: "new",
+			want:    "a\n# BEGIN app\nnew\n# END app\nb\n",
+		},
+		{
+			name:    "multiple lines and trailing newlines",
+			text:    "a\n",
+			content: "one\ntwo\n\n",
+			want:    "a\n# BEGIN app\none\ntwo\n# END app\n",
+		},
+		{
+			name:    "only the first block",
+			text:    "# BEGIN app\nold\n# END app\n# BEGIN app\nother\n# END app\n",
+			content: "new",
+			want:    "# BEGIN app\nnew\n# END app\n# BEGIN app\nother\n# END app\n",
+		},
+		{
+			name:    "other markers untouched",
+			text:    "# BEGIN other\nx\n# END other\n",
+			content: "new",
+			want:    "# BEGIN other\nx\n# END other\n# BEGIN app\nnew\n# END app\n",
+		},
+		{
+			name:    "CRLF appended",
+			text:    "a\r\nb",
+			content: "new",
+			want:    "a\r\nb\r\n# BEGIN app\r\nnew\r\n# END app\r\n",
+		},
+		{
+			name:    "CRLF replaced",
+			text:    "a\r\n# BEGIN app\r\nold\r\n# END app\r\nb\r\n",
+			content: "one\r\ntwo\r\n",
+			want:    "a\r\n# BEGIN app\r\none\r\ntwo\r\n# END app\r\nb\r\n",
+		},
+		{
+			name:   "removed",
+			text:   "a\n# BEGIN app\nold\n# END app\nb\n",
+			remove: true,
+			want:   "a\nb\n",
+		},
+		{
+			name:   "CRLF removed",
+			text:   "a\r\n# BEGIN app\r\nold\r\n# END app\r\n",
+			remove: true,
+			want:   "a\r\n",
+		},
+		{
+			name:   "removed when missing",
+			text:   "a\nb",
+			remove: true,
+			want:   "a\nb",
+		},
+		{
+			name:   "removed from empty file",
+			text:   "",
+			remove: true,
+			want:   "",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := updateManagedBlock(test.text, begin, end, test.content, test.remove)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUpdateManagedBlockNoEnd(t *testing.T) {
+	for _, remove := range []bool{false, true} {
+		_, err := updateManagedBlock("a\n# BEGIN app\nold\n", "# BEGIN app", "# END app", "new", remove)
+		if err == nil {
+			t.Errorf("block without end marker was accepted (remove: %v)", remove)
+		}
+	}
+}
+
+func TestManagedBlockSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs administrator permissions on Windows")
+	}
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "hosts")
+	link := filepath.Join(dir, "link")
+
+	err := ioutil.WriteFile(target, []byte("127.0.0.1 localhost\n"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink("hosts", link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ManagedBlockSudoAction{Path: link, Marker: "app", Content: "127.0.0.1 app.local"}.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("symlink was replaced")
+	}
+
+	text, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "127.0.0.1 localhost\n# BEGIN app\n127.0.0.1 app.local\n# END app\n"
+	if string(text) != want {
+		t.Errorf("target is %q, want %q", text, want)
+	}
+
+	info, err = os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("target mode is %v, want 0640", info.Mode().Perm())
+	}
+}
+
+func TestManagedBlockRemoveMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+
+	_, err := ManagedBlockSudoAction{Path: path, Marker: "app", Remove: true}.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Lstat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("removing a block created %s", path)
+	}
+}
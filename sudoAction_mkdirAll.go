@@ -0,0 +1,70 @@
+package clicommon
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const defaultDirPermissions = 0755
+
+// MkdirAllSudoAction creates the directory at Path along with any missing
+// parents, like mkdir -p. The directories it creates get Mode regardless of the
+// umask, while existing ones are left alone.
+type MkdirAllSudoAction struct {
+	Path string
+
+	// Mode defaults to 0755
+	Mode os.FileMode
+}
+
+func (a MkdirAllSudoAction) Name() string {
+	return ActionName(standardActionNamespace, "mkdirAll")
+}
+
+func (a MkdirAllSudoAction) Describe() string {
+	return "Create the directory " + a.Path
+}
+
+func (a MkdirAllSudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:     "Path",
+			Type:     SudoParamPath,
+			Required: true,
+		},
+	}
+}
+
+func (a MkdirAllSudoAction) Run() (interface{}, error) {
+	mode := a.Mode
+	if mode == 0 {
+		mode = defaultDirPermissions
+	}
+
+	// The directories that are missing, from Path up
+	var missing []string
+
+	for dir := filepath.Clean(a.Path); ; dir = filepath.Dir(dir) {
+		_, err := os.Lstat(dir)
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			break
+		}
+
+		missing = append(missing, dir)
+	}
+
+	err := os.MkdirAll(a.Path, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// MkdirAll applies the umask
+	for _, dir := range missing {
+		err = os.Chmod(dir, mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package clicommon
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMkdirAllMode(t *testing.T) {
+	umask := syscall.Umask(0022)
+	defer syscall.Umask(umask)
+
+	base := t.TempDir()
+
+	err := os.Chmod(base, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(base, "a", "b")
+
+	_, err = MkdirAllSudoAction{Path: path, Mode: 0777}.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{filepath.Join(base, "a"), path} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != 0777 {
+			t.Errorf("%s has mode %v, want 0777", dir, info.Mode().Perm())
+		}
+	}
+
+	info, err := os.Stat(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("existing directory's mode changed to %v", info.Mode().Perm())
+	}
+}
@@ -1,44 +1,222 @@
 package clicommon
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"runtime"
+	"sync"
 
 	"github.com/kardianos/osext"
 )
 
+// replacedExecutableSuffix is added to the name of this program's executable
+// for the backup of the previous version
+const replacedExecutableSuffix = ".old"
+
+var (
+	executableSigningKeyLock   sync.Mutex
+	executableSigningKey       ed25519.PublicKey
+	unsignedExecutablesAllowed bool
+
+	errExecutableSigningKeyMissing = errors.New("no signing key is set for replacing this program, see SetExecutableSigningKey")
+)
+
 func init() {
 	RegisterAction(ReplaceExecutableSudoAction{})
 }
 
+// SetExecutableSigningKey sets the public key new versions of this program must
+// be signed with to be installed by ReplaceExecutableSudoAction, e.g. updates
+// of a program in a directory only root can write to. Without it that action
+// always fails, since anyone who may run this program with sudo could use it
+// to replace the program with anything. It has to be called before TryHandleSudo
+// or HandleSudo, since the elevated process checks the signature.
+func SetExecutableSigningKey(key ed25519.PublicKey) {
+	executableSigningKeyLock.Lock()
+	defer executableSigningKeyLock.Unlock()
+
+	executableSigningKey = key
+}
+
+// AllowUnsignedExecutables lets ReplaceExecutableSudoAction install executables
+// without a signature when no signing key is set, like it did before signatures
+// were required. Anyone who may run this program with sudo can then replace it
+// with anything, so this is only for programs whose sudo users may run anything
+// as root anyway. Like SetExecutableSigningKey, it has to be called before
+// TryHandleSudo or HandleSudo.
+func AllowUnsignedExecutables() {
+	executableSigningKeyLock.Lock()
+	defer executableSigningKeyLock.Unlock()
+
+	unsignedExecutablesAllowed = true
+}
+
+// currentExecutableSigningKey gets the key set by SetExecutableSigningKey, and
+// whether executables may be unsigned because there is none
+func currentExecutableSigningKey() (ed25519.PublicKey, bool) {
+	executableSigningKeyLock.Lock()
+	defer executableSigningKeyLock.Unlock()
+
+	return executableSigningKey, executableSigningKey == nil && unsignedExecutablesAllowed
+}
+
+// ReplaceExecutableSudoAction replaces this program's executable with the one
+// at NewExe, e.g. after an update was downloaded. NewExe is copied, so it can
+// be on a different filesystem, and the copy keeps the owner and mode of the
+// executable it replaces. The previous executable is kept next to it with an
+// .old suffix, and stays in place if the replacement fails. NewExe is left for
+// the caller to remove, since its path is controlled by the invoking user.
+//
+// NewExe must be signed with the key set by SetExecutableSigningKey, since
+// anyone who may run this program with sudo could run this action with any
+// executable, unless AllowUnsignedExecutables was called.
 type ReplaceExecutableSudoAction struct {
 	NewExe string
+
+	// Signature is NewExe's ed25519 signature, hex-encoded, which is ignored
+	// for unsigned executables
+	Signature string
 }
 
 func (a ReplaceExecutableSudoAction) Name() string {
 	return "replaceExecutable"
 }
 
+func (a ReplaceExecutableSudoAction) Describe() string {
+	return "Replace this program with " + a.NewExe
+}
+
 func (a ReplaceExecutableSudoAction) Params() []string {
-	return []string{a.NewExe}
+	return []string{a.NewExe, a.Signature}
+}
+
+func (a ReplaceExecutableSudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:           "NewExe",
+			Type:           SudoParamPath,
+			Required:       true,
+			RegularFile:    true,
+			OwnedByInvoker: true,
+		},
+		{
+			Name: "Signature",
+			Type: SudoParamString,
+		},
+	}
 }
 
 func (a ReplaceExecutableSudoAction) Handle(params []string) error {
-	if len(params) < 1 {
+	if len(params) < 2 {
 		return errors.New("not enough parameters for ReplaceExecutableSudoAction")
 	}
 
 	newExe := params[0]
 
+	key, unsigned := currentExecutableSigningKey()
+	if key == nil && !unsigned {
+		return errExecutableSigningKeyMissing
+	}
+
+	source, err := OpenInvokingUserFile(newExe)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// The content is read once, so the file can't be changed after its
+	// signature was checked
+	content, err := ioutil.ReadAll(source)
+	if err != nil {
+		return err
+	}
+
+	if key != nil {
+		signature, err := hex.DecodeString(params[1])
+		if err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+
+		if !ed25519.Verify(key, content, signature) {
+			return fmt.Errorf("%s is not signed with this program's signing key", newExe)
+		}
+	}
+
 	thisExe, err := osext.Executable()
 	if err != nil {
 		return err
 	}
 
-	err = os.Rename(newExe, thisExe)
+	return replaceExecutable(thisExe, bytes.NewReader(content))
+}
+
+// replaceExecutable replaces the executable at filename with content, keeping
+// the previous one as a backup. The new executable is renamed over the previous
+// one, so there always is one at filename, except on Windows, where a running
+// executable can't be replaced but can be moved within its directory, so it's
+// moved to the backup first and moved back if that fails.
+func replaceExecutable(filename string, content io.Reader) error {
+	info, err := os.Stat(filename)
 	if err != nil {
 		return err
 	}
 
+	uid, gid := fileOwner(info)
+
+	// Replaces the backup of the version before, if there is one
+	backup := filename + replacedExecutableSuffix
+
+	if runtime.GOOS != "windows" {
+		err = backupExecutable(filename, backup, info, uid, gid)
+		if err != nil {
+			return fmt.Errorf("backing up executable: %w", err)
+		}
+
+		return writeFileAtomic(filename, content, info.Mode().Perm(), uid, gid)
+	}
+
+	err = os.Rename(filename, backup)
+	if err != nil {
+		return fmt.Errorf("backing up executable: %w", err)
+	}
+
+	err = writeFileAtomic(filename, content, info.Mode().Perm(), uid, gid)
+	if err != nil {
+		restoreErr := os.Rename(backup, filename)
+		if restoreErr != nil {
+			return fmt.Errorf("%w (restoring the previous executable from %s failed too: %v)", err, backup, restoreErr)
+		}
+
+		return err
+	}
+
 	return nil
 }
+
+// backupExecutable makes backup another link to the executable at filename, or
+// a copy of it if the filesystem doesn't support links
+func backupExecutable(filename, backup string, info os.FileInfo, uid, gid int) error {
+	err := os.Remove(backup)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.Link(filename, backup)
+	if err == nil {
+		return nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writeFileAtomic(backup, file, info.Mode().Perm(), uid, gid)
+}
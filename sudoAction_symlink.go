@@ -0,0 +1,65 @@
+package clicommon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SymlinkSudoAction creates a symlink at Path pointing to Target, atomically
+// replacing whatever was at Path before unless it's a directory
+type SymlinkSudoAction struct {
+	Target string
+	Path   string
+}
+
+func (a SymlinkSudoAction) Name() string {
+	return ActionName(standardActionNamespace, "symlink")
+}
+
+func (a SymlinkSudoAction) Describe() string {
+	return fmt.Sprintf("Link %s to %s", a.Path, a.Target)
+}
+
+func (a SymlinkSudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:     "Path",
+			Type:     SudoParamPath,
+			Required: true,
+		},
+	}
+}
+
+func (a SymlinkSudoAction) Run() (interface{}, error) {
+	if info, err := os.Lstat(a.Path); err == nil && info.IsDir() {
+		return nil, errors.New("symlink path is a directory")
+	}
+
+	suffix := make([]byte, 8)
+
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the symlink under a temporary name first, since renaming over the
+	// old file is atomic but removing it and then creating the symlink isn't
+	tmpPath := filepath.Join(filepath.Dir(a.Path), "."+filepath.Base(a.Path)+"."+hex.EncodeToString(suffix))
+
+	err = os.Symlink(a.Target, tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmpPath, a.Path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+package clicommon
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const defaultFilePermissions = 0644
+
+// WriteFileSudoAction atomically replaces the file at Path with Content, by
+// writing a temporary file next to it and renaming that over it
+type WriteFileSudoAction struct {
+	Path    string
+	Content []byte
+
+	// Mode defaults to 0644
+	Mode os.FileMode
+
+	// UID and GID default to root, and are ignored on Windows
+	UID int
+	GID int
+}
+
+func (a WriteFileSudoAction) Name() string {
+	return ActionName(standardActionNamespace, "writeFile")
+}
+
+func (a WriteFileSudoAction) Describe() string {
+	return "Write " + a.Path
+}
+
+func (a WriteFileSudoAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{
+			Name:     "Path",
+			Type:     SudoParamPath,
+			Required: true,
+		},
+	}
+}
+
+func (a WriteFileSudoAction) Run() (interface{}, error) {
+	mode := a.Mode
+	if mode == 0 {
+		mode = defaultFilePermissions
+	}
+
+	return nil, writeFileAtomic(a.Path, bytes.NewReader(a.Content), mode, a.UID, a.GID)
+}
+
+// writeFileAtomic writes content to a temporary file in the same directory as
+// filename, sets its mode and owner, and then renames it to filename so that
+// readers only ever see the old or the complete new file. An owner ID of -1
+// keeps the owner of the new file unchanged, and owners are ignored on Windows.
+func writeFileAtomic(filename string, content io.Reader, mode os.FileMode, uid, gid int) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+
+	// Does nothing once the file has been renamed
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if err == nil && runtime.GOOS != "windows" && (uid != -1 || gid != -1) {
+		err = file.Chown(uid, gid)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filename)
+}
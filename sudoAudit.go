@@ -0,0 +1,313 @@
+package clicommon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of sudo actions in the audit log
+const (
+	SudoAuditSuccess = "success"
+	SudoAuditFailure = "failure"
+	SudoAuditSkipped = "skipped"
+)
+
+var (
+	sudoAuditLock sync.Mutex
+	sudoAuditFile *rotatingLogFile
+	sudoDryRun    bool
+
+	// sudoSystemAuditPath is the audit log written by the elevated process
+	sudoSystemAuditPath string
+)
+
+// sudoAuditEntry is a single line of the audit log
+type sudoAuditEntry struct {
+	Time       string      `json:"time"`
+	User       string      `json:"user"`
+	RunAs      string      `json:"runAs,omitempty"`
+	Action     string      `json:"action"`
+	Params     interface{} `json:"params,omitempty"`
+	Outcome    string      `json:"outcome"`
+	Error      string      `json:"error,omitempty"`
+	RolledBack bool        `json:"rolledBack,omitempty"`
+}
+
+// OpenSudoAuditLog starts appending every sudo action run by this process to an
+// audit log named after the config dir inside of its state directory, one JSON
+// object per line. Params marked as Secret in an action's schema and registered
+// secrets are redacted.
+//
+// This log is written by the invoking process, so it's only a record for the
+// user, who can change it, and it misses actions run without CallSudo. Use
+// OpenSudoAuditLogFile for a log the user can't tamper with.
+func OpenSudoAuditLog(configDir *UserConfigDir) error {
+	dir, err := configDir.GetStateDir()
+	if err != nil {
+		return err
+	}
+
+	file := &rotatingLogFile{
+		path:     filepath.Join(dir, configDir.name+"-audit.log"),
+		maxSize:  defaultLogFileMaxSize,
+		maxFiles: defaultLogFileMaxFiles,
+	}
+
+	err = file.open()
+	if err != nil {
+		return err
+	}
+
+	sudoAuditLock.Lock()
+	defer sudoAuditLock.Unlock()
+
+	if sudoAuditFile != nil {
+		sudoAuditFile.close()
+	}
+
+	sudoAuditFile = file
+
+	return nil
+}
+
+// OpenSudoAuditLogFile sets a system-wide audit log, like one in /var/log,
+// which is written by the elevated process itself instead of the invoking one.
+// It can be somewhere only root can write to, so the user can't tamper with
+// it, and every action is recorded, including ones run by calling this program
+// through sudo directly instead of through CallSudo. It has to be called before
+// TryHandleSudo or HandleSudo, with the same path in every process. The log is
+// in the same format as OpenSudoAuditLog's, with the user that requested the
+// actions.
+func OpenSudoAuditLogFile(filename string) error {
+	if !filepath.IsAbs(filename) {
+		return fmt.Errorf("audit log path %s is not absolute", filename)
+	}
+
+	sudoAuditLock.Lock()
+	defer sudoAuditLock.Unlock()
+
+	sudoSystemAuditPath = filename
+
+	return nil
+}
+
+// CloseSudoAuditLog stops writing to the audit logs, if any were opened
+func CloseSudoAuditLog() error {
+	sudoAuditLock.Lock()
+	defer sudoAuditLock.Unlock()
+
+	sudoSystemAuditPath = ""
+
+	if sudoAuditFile == nil {
+		return nil
+	}
+
+	err := sudoAuditFile.close()
+	sudoAuditFile = nil
+
+	return err
+}
+
+// SetSudoDryRun turns dry-run mode on or off. In dry-run mode sudo actions
+// aren't run at all, and instead the actions and params that would have been
+// run are printed, without asking for superuser permissions. Typed actions
+// don't return a result then.
+func SetSudoDryRun(dryRun bool) {
+	sudoAuditLock.Lock()
+	defer sudoAuditLock.Unlock()
+
+	sudoDryRun = dryRun
+}
+
+// IsSudoDryRun reports if dry-run mode is on
+func IsSudoDryRun() bool {
+	sudoAuditLock.Lock()
+	defer sudoAuditLock.Unlock()
+
+	return sudoDryRun
+}
+
+// printSudoDryRun prints the steps that would have been run in dry-run mode, as
+// runAs or root if it's empty
+func printSudoDryRun(steps []*sudoStep, runAs string) {
+	for _, step := range steps {
+		params, _ := json.Marshal(redactSudoStep(step))
+
+		if runAs != "" {
+			fmt.Printf("Would run sudo action %s as %s %s\n", step.Action, runAs, Redact(string(params)))
+		} else {
+			fmt.Printf("Would run sudo action %s %s\n", step.Action, Redact(string(params)))
+		}
+	}
+}
+
+// auditSudoSteps appends the outcome of every step of a payload to the user's
+// audit log. The response is nil if the elevated process never sent one, in
+// which case err is why.
+func auditSudoSteps(payload *sudoPayload, response *sudoResponse, err error) {
+	sudoAuditLock.Lock()
+	defer sudoAuditLock.Unlock()
+
+	if sudoAuditFile == nil {
+		return
+	}
+
+	writeSudoAuditEntries(sudoAuditFile, currentUsername(), payload, response, err)
+}
+
+// auditElevatedSudoSteps appends the outcome of every step of a payload to the
+// system-wide audit log from the elevated process, if one was set
+func auditElevatedSudoSteps(payload *sudoPayload, response *sudoResponse) {
+	sudoAuditLock.Lock()
+	defer sudoAuditLock.Unlock()
+
+	if sudoSystemAuditPath == "" {
+		return
+	}
+
+	file := &rotatingLogFile{
+		path:     sudoSystemAuditPath,
+		maxSize:  defaultLogFileMaxSize,
+		maxFiles: defaultLogFileMaxFiles,
+	}
+
+	err := file.open()
+	if err != nil {
+		Log.Warn("Error opening sudo audit log", "path", sudoSystemAuditPath, "error", err)
+		return
+	}
+	defer file.close()
+
+	uid, _ := InvokingUserIDs()
+	username := strconv.Itoa(uid)
+	if invoker, err := LookupInvokingUser(); err == nil {
+		username = invoker.Username
+	}
+
+	writeSudoAuditEntries(file, username, payload, response, nil)
+}
+
+// writeSudoAuditEntries writes an audit log entry for every step of a payload
+func writeSudoAuditEntries(file *rotatingLogFile, username string, payload *sudoPayload, response *sudoResponse, err error) {
+	now := time.Now().Format(time.RFC3339)
+
+	for i, step := range payload.Steps {
+		entry := sudoAuditEntry{
+			Time:   now,
+			User:   username,
+			RunAs:  payload.User,
+			Action: step.Action,
+			Params: redactSudoStep(step),
+		}
+
+		switch {
+		case response == nil:
+			entry.Outcome = SudoAuditFailure
+			if err != nil {
+				entry.Error = err.Error()
+			}
+
+		case response.Error == nil || i < response.FailedStep:
+			entry.Outcome = SudoAuditSuccess
+			entry.RolledBack = response.Error != nil && payload.Rollback
+
+		case i == response.FailedStep:
+			entry.Outcome = SudoAuditFailure
+			entry.Error = response.Error.Error()
+
+		default:
+			entry.Outcome = SudoAuditSkipped
+		}
+
+		line, marshalErr := json.Marshal(&entry)
+		if marshalErr != nil {
+			continue
+		}
+
+		writeErr := file.write(Redact(string(line)) + "\n")
+		if writeErr != nil {
+			Log.Warn("Error writing sudo audit log", "error", writeErr)
+		}
+	}
+}
+
+// redactSudoStep gets the params of a step for showing to the user, with the
+// params its registered action marks as secret replaced
+func redactSudoStep(step *sudoStep) interface{} {
+	handler, actionType := lookupAction(step.Action)
+
+	if actionType != nil {
+		params := map[string]interface{}{}
+
+		if len(step.Data) > 0 && json.Unmarshal(step.Data, &params) != nil {
+			return nil
+		}
+
+		typedAction, err := decodeTypedAction(actionType, nil)
+		if err != nil {
+			return params
+		}
+
+		action, ok := typedAction.(SchemaSudoAction)
+		if !ok {
+			return params
+		}
+
+		structType := actionType
+		for structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+
+		for _, param := range action.ParamSchema() {
+			if !param.Secret {
+				continue
+			}
+
+			if field, ok := structType.FieldByName(param.Name); ok {
+				if _, ok := params[jsonFieldName(field)]; ok {
+					params[jsonFieldName(field)] = redactedSecret
+				}
+			}
+		}
+
+		return params
+	}
+
+	params := append([]string(nil), step.Params...)
+
+	if action, ok := handler.(SchemaSudoAction); ok {
+		for i, param := range action.ParamSchema() {
+			if param.Secret && i < len(params) {
+				params[i] = redactedSecret
+			}
+		}
+	}
+
+	return params
+}
+
+// jsonFieldName gets the key a struct field is encoded as by encoding/json
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
+
+func currentUsername() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return strconv.Itoa(os.Getuid())
+}
@@ -0,0 +1,95 @@
+package clicommon
+
+import (
+	"context"
+	"fmt"
+)
+
+const sudoBatchActionName = "batch"
+
+// CompensatedSudoAction is a sudo action that can be undone by another action,
+// which CallSudoBatch runs to roll back the batch if a later action fails. The
+// compensating action must be registered like any other action.
+type CompensatedSudoAction interface {
+	Compensation() AnySudoAction
+}
+
+// SudoBatchOptions configures CallSudoBatch
+type SudoBatchOptions struct {
+	// Rollback runs the compensating action of every completed action, in
+	// reverse order, when an action fails
+	Rollback bool
+}
+
+// SudoBatchError is returned by CallSudoBatch when one of its actions fails
+type SudoBatchError struct {
+	// Index is the position of the failed action in the batch
+	Index int
+
+	// Action is the name of the failed action
+	Action string
+
+	// Err is the failed action's error
+	Err *SudoError
+
+	// RollbackErrors holds the errors of any compensating actions that failed
+	// while rolling back
+	RollbackErrors []*SudoError
+}
+
+func (e *SudoBatchError) Error() string {
+	msg := fmt.Sprintf("sudo action %d (%s) failed: %s", e.Index+1, e.Action, e.Err)
+
+	if len(e.RollbackErrors) > 0 {
+		msg += fmt.Sprintf(" (and %d actions failed to roll back)", len(e.RollbackErrors))
+	}
+
+	return msg
+}
+
+func (e *SudoBatchError) Unwrap() error {
+	return e.Err
+}
+
+// CallSudoBatch runs several SudoActions or TypedSudoActions in order within a
+// single elevated process, so the user is asked for permission only once. It
+// stops at the first action that fails and returns a *SudoBatchError.
+func CallSudoBatch(actions []AnySudoAction, options SudoBatchOptions) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	payload := &sudoPayload{
+		Action:   sudoBatchActionName,
+		Rollback: options.Rollback,
+	}
+
+	for _, action := range actions {
+		step, err := encodeSudoStep(action)
+		if err != nil {
+			return err
+		}
+
+		payload.Steps = append(payload.Steps, step)
+	}
+
+	response, err := callSudoPayload(context.Background(), payload, SudoCallOptions{})
+	if err != nil {
+		return err
+	}
+
+	if response.Error != nil {
+		if response.FailedStep < 0 || response.FailedStep >= len(actions) {
+			return response.Error
+		}
+
+		return &SudoBatchError{
+			Index:          response.FailedStep,
+			Action:         actions[response.FailedStep].Name(),
+			Err:            response.Error,
+			RollbackErrors: response.RollbackErrors,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package clicommon
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	sudoChannelPrefix      = "clicommon-sudo-"
+	sudoChannelPermissions = 0700
+	sudoPayloadFilename    = "payload"
+	sudoPayloadPermissions = 0600
+	sudoResponseFilename   = "response"
+
+	// sudoStartedFilename is created by the elevated process once it has
+	// read its payload, so the escalator is done asking for a password
+	sudoStartedFilename = "started"
+
+	// The response is written by the elevated user, but still needs to be
+	// readable by the invoking user. The channel directory keeps it private.
+	sudoResponsePermissions = 0644
+
+	// A channel for an action that runs as another user than root can't be
+	// private to the invoking user, so that user can enter it and create the
+	// response, but not list it. Files in it can only be found by their names,
+	// which are derived from the key.
+	sudoSharedChannelPermissions = 0733
+	sudoSharedPayloadPermissions = 0644
+
+	sudoKeySize = 32
+
+	// How long the elevated process has to pick up a payload, which includes
+	// however long the user takes to type their password
+	sudoPayloadMaxAge = 5 * time.Minute
+)
+
+// sudoPayload is everything the elevated process needs to run an action. It's
+// passed through a private directory instead of the command line, since the
+// command line of every process is visible to every user.
+type sudoPayload struct {
+	Action   string      `json:"action"`
+	IssuedAt int64       `json:"issuedAt"`
+	Steps    []*sudoStep `json:"steps"`
+	Rollback bool        `json:"rollback,omitempty"`
+
+	// User is who the steps run as, or root if it's empty
+	User string `json:"user,omitempty"`
+
+	// IdleTimeout is only used by a sudo helper
+	IdleTimeout time.Duration `json:"idleTimeout,omitempty"`
+}
+
+// sudoStep is a single action to run in the elevated process, along with the
+// action that undoes it if it has one
+type sudoStep struct {
+	Action       string          `json:"action"`
+	Params       []string        `json:"params,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+	Compensation *sudoStep       `json:"compensation,omitempty"`
+
+	// description is shown when confirming the step, and isn't sent
+	description string
+}
+
+// sudoResponse is the outcome of the steps, sent back from the elevated process
+// through the same private directory as the payload
+type sudoResponse struct {
+	Results        []json.RawMessage `json:"results,omitempty"`
+	Error          *SudoError        `json:"error,omitempty"`
+	FailedStep     int               `json:"failedStep,omitempty"`
+	RollbackErrors []*SudoError      `json:"rollbackErrors,omitempty"`
+}
+
+// sudoEnvelope is the payload file's content, signed with a one-time key that
+// the elevated process gets separately from the payload file
+type sudoEnvelope struct {
+	Payload json.RawMessage `json:"payload"`
+	MAC     string          `json:"mac"`
+}
+
+func newSudoKey() ([]byte, error) {
+	key := make([]byte, sudoKeySize)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// writeSudoPayload signs the payload with key and writes it into a new private
+// directory, returning the path of that directory, which is the one-time handle
+// the elevated process gets on its command line. If the payload runs as another
+// user the directory is shared instead.
+func writeSudoPayload(payload *sudoPayload, key []byte) (string, error) {
+	payload.IssuedAt = time.Now().Unix()
+
+	payloadText, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	text, err := json.Marshal(&sudoEnvelope{
+		Payload: payloadText,
+		MAC:     hex.EncodeToString(signSudoPayload(payloadText, key)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	tempDir := ""
+	perm := os.FileMode(sudoPayloadPermissions)
+
+	if payload.User != "" {
+		tempDir = sharedTempDir()
+		perm = sudoSharedPayloadPermissions
+	}
+
+	// TempDir creates the directory with 0700 permissions
+	dir, err := ioutil.TempDir(tempDir, sudoChannelPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	if payload.User != "" {
+		err = os.Chmod(dir, sudoSharedChannelPermissions)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+
+	err = writeNewFile(sudoChannelFile(dir, key, sudoPayloadFilename), text, perm)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// checkSudoHandle makes sure a handle from the command line is a private or
+// shared directory created by writeSudoPayload, before anything inside of it is
+// touched by the elevated process
+func checkSudoHandle(handle string) (shared bool, err error) {
+	if !filepath.IsAbs(handle) || !strings.HasPrefix(filepath.Base(handle), sudoChannelPrefix) {
+		return false, errors.New("invalid sudo payload handle")
+	}
+
+	info, err := os.Lstat(handle)
+	if err != nil {
+		return false, err
+	}
+
+	if !info.IsDir() {
+		return false, errors.New("sudo payload handle is not a directory")
+	}
+
+	shared = info.Mode().Perm() == sudoSharedChannelPermissions
+	if shared {
+		return true, checkSudoChannelFile(info, sudoSharedChannelPermissions)
+	}
+
+	return false, checkSudoChannelFile(info, sudoChannelPermissions)
+}
+
+// readSudoPayload reads the payload behind a handle from writeSudoPayload,
+// verifies that it was signed with key for the given action, and removes it so
+// that every handle can only be used once. The directory itself is left for the
+// response, and is removed by the invoking process.
+func readSudoPayload(handle, action string, key []byte, shared bool) (*sudoPayload, error) {
+	filename := sudoChannelFile(handle, key, sudoPayloadFilename)
+	defer os.Remove(filename)
+
+	info, err := os.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("sudo payload is not a regular file")
+	}
+
+	perm := os.FileMode(sudoPayloadPermissions)
+	if shared {
+		perm = sudoSharedPayloadPermissions
+	}
+
+	err = checkSudoChannelFile(info, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := sudoEnvelope{}
+
+	err = json.Unmarshal(text, &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := hex.DecodeString(envelope.MAC)
+	if err != nil || !hmac.Equal(mac, signSudoPayload(envelope.Payload, key)) {
+		return nil, errors.New("sudo payload signature is invalid")
+	}
+
+	payload := &sudoPayload{}
+
+	err = json.Unmarshal(envelope.Payload, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload.Action != action {
+		return nil, errors.New("sudo payload is for a different action")
+	}
+
+	if shared != (payload.User != "") {
+		return nil, errors.New("sudo payload channel has the wrong permissions")
+	}
+
+	issuedAt := time.Unix(payload.IssuedAt, 0)
+	if age := time.Since(issuedAt); age > sudoPayloadMaxAge || age < -time.Minute {
+		return nil, errors.New("sudo payload has expired")
+	}
+
+	return payload, nil
+}
+
+// writeSudoResponse writes the outcome of an action for the invoking process to
+// read once the elevated process has exited
+func writeSudoResponse(handle string, key []byte, response *sudoResponse) error {
+	text, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	return writeNewFile(sudoChannelFile(handle, key, sudoResponseFilename), text, sudoResponsePermissions)
+}
+
+// markSudoStarted tells the invoking process that the elevated process is
+// running, see sudoStarted
+func markSudoStarted(handle string, key []byte) error {
+	return writeNewFile(sudoChannelFile(handle, key, sudoStartedFilename), nil, sudoResponsePermissions)
+}
+
+// sudoStarted reports if the elevated process has started running
+func sudoStarted(handle string, key []byte) bool {
+	_, err := os.Lstat(sudoChannelFile(handle, key, sudoStartedFilename))
+	return err == nil
+}
+
+// readSudoResponse reads the outcome of an action, or returns nil if the
+// elevated process never wrote one
+func readSudoResponse(handle string, key []byte) (*sudoResponse, error) {
+	text, err := ioutil.ReadFile(sudoChannelFile(handle, key, sudoResponseFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	response := &sudoResponse{}
+
+	err = json.Unmarshal(text, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// sudoChannelFile gets the path of a file in a channel directory, whose name is
+// derived from the key so it can't be found by anyone without it
+func sudoChannelFile(handle string, key []byte, name string) string {
+	return filepath.Join(handle, hex.EncodeToString(signSudoPayload([]byte(name), key)))
+}
+
+func signSudoPayload(payload []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
+
+// writeNewFile writes a file that must not exist yet, which also makes sure it
+// isn't a symlink planted to redirect the write somewhere else
+func writeNewFile(filename string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,350 @@
+package clicommon
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// clearInvokingUser makes this process the invoking user for the test, so the
+// channel files it creates pass the owner checks
+func clearInvokingUser(t *testing.T) {
+	for _, name := range []string{"SUDO_UID", "SUDO_GID", "PKEXEC_UID", "DOAS_USER"} {
+		name := name
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		os.Unsetenv(name)
+
+		t.Cleanup(func() {
+			os.Setenv(name, value)
+		})
+	}
+}
+
+// setInvokingUser makes uid look like the invoking user for the test, like it
+// would in a process started through sudo
+func setInvokingUser(t *testing.T, uid int) {
+	clearInvokingUser(t)
+
+	os.Setenv("SUDO_UID", strconv.Itoa(uid))
+	os.Setenv("SUDO_GID", strconv.Itoa(uid))
+
+	t.Cleanup(func() {
+		os.Unsetenv("SUDO_UID")
+		os.Unsetenv("SUDO_GID")
+	})
+}
+
+func newTestSudoKey(t *testing.T) []byte {
+	key, err := newSudoKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func newTestSudoPayload() *sudoPayload {
+	return &sudoPayload{
+		Action: "run",
+		Steps: []*sudoStep{
+			{Action: "test.params", Params: []string{"a", "b c"}},
+			{Action: "test.typed", Data: json.RawMessage(`{"Path":"/tmp/x"}`)},
+		},
+		Rollback: true,
+	}
+}
+
+// writeTestSudoPayload writes a payload signed with key into a new private
+// channel like writeSudoPayload does, but without setting IssuedAt
+func writeTestSudoPayload(t *testing.T, payload *sudoPayload, key []byte) string {
+	payloadText, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := json.Marshal(&sudoEnvelope{
+		Payload: payloadText,
+		MAC:     hex.EncodeToString(signSudoPayload(payloadText, key)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", sudoChannelPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	err = writeNewFile(sudoChannelFile(dir, key, sudoPayloadFilename), text, sudoPayloadPermissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func writeSudoPayloadForTest(t *testing.T, payload *sudoPayload, key []byte) string {
+	handle, err := writeSudoPayload(payload, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(handle) })
+
+	return handle
+}
+
+func TestSudoPayloadRoundTrip(t *testing.T) {
+	clearInvokingUser(t)
+
+	key := newTestSudoKey(t)
+	payload := newTestSudoPayload()
+
+	handle := writeSudoPayloadForTest(t, payload, key)
+
+	shared, err := checkSudoHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shared {
+		t.Fatal("channel for root is shared")
+	}
+
+	got, err := readSudoPayload(handle, "run", key, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, payload) {
+		t.Errorf("read payload %+v, wrote %+v", got, payload)
+	}
+
+	// Every handle can only be used once
+	_, err = readSudoPayload(handle, "run", key, shared)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("reading payload twice: got %v, want it to not exist", err)
+	}
+}
+
+func TestSudoPayloadRoundTripShared(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("actions can't run as another user on Windows")
+	}
+
+	clearInvokingUser(t)
+
+	key := newTestSudoKey(t)
+	payload := newTestSudoPayload()
+	payload.User = "deploy"
+
+	handle := writeSudoPayloadForTest(t, payload, key)
+
+	shared, err := checkSudoHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !shared {
+		t.Fatal("channel for another user isn't shared")
+	}
+
+	got, err := readSudoPayload(handle, "run", key, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.User != "deploy" || !reflect.DeepEqual(got.Steps, payload.Steps) {
+		t.Errorf("read payload %+v, wrote %+v", got, payload)
+	}
+}
+
+func TestSudoPayloadOwnedByElevatedUser(t *testing.T) {
+	// A process that became root through sudo runs actions directly, so the
+	// channel is its own while SUDO_UID names the user that ran sudo
+	setInvokingUser(t, os.Geteuid()+1000)
+
+	key := newTestSudoKey(t)
+	payload := newTestSudoPayload()
+
+	handle := writeSudoPayloadForTest(t, payload, key)
+
+	shared, err := checkSudoHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readSudoPayload(handle, "run", key, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, payload) {
+		t.Errorf("read payload %+v, wrote %+v", got, payload)
+	}
+}
+
+func TestSudoPayloadTampered(t *testing.T) {
+	clearInvokingUser(t)
+
+	tests := map[string]func(envelope *sudoEnvelope){
+		"payload": func(envelope *sudoEnvelope) {
+			payload := sudoPayload{}
+			json.Unmarshal(envelope.Payload, &payload)
+
+			payload.Steps[0].Params[0] = "/etc/shadow"
+			envelope.Payload, _ = json.Marshal(&payload)
+		},
+		"mac": func(envelope *sudoEnvelope) {
+			mac, _ := hex.DecodeString(envelope.MAC)
+			mac[0] ^= 1
+			envelope.MAC = hex.EncodeToString(mac)
+		},
+		"invalid mac": func(envelope *sudoEnvelope) {
+			envelope.MAC = "not hex"
+		},
+		"no mac": func(envelope *sudoEnvelope) {
+			envelope.MAC = ""
+		},
+	}
+
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			key := newTestSudoKey(t)
+			handle := writeSudoPayloadForTest(t, newTestSudoPayload(), key)
+			filename := sudoChannelFile(handle, key, sudoPayloadFilename)
+
+			text, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			envelope := sudoEnvelope{}
+
+			err = json.Unmarshal(text, &envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tamper(&envelope)
+
+			text, err = json.Marshal(&envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ioutil.WriteFile(filename, text, sudoPayloadPermissions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = readSudoPayload(handle, "run", key, false)
+			if err == nil {
+				t.Fatal("tampered payload was accepted")
+			}
+		})
+	}
+}
+
+func TestSudoPayloadWrongKey(t *testing.T) {
+	clearInvokingUser(t)
+
+	key := newTestSudoKey(t)
+	wrongKey := newTestSudoKey(t)
+
+	handle := writeSudoPayloadForTest(t, newTestSudoPayload(), key)
+
+	// The payload's file name is derived from the key, so it can't be found
+	// without it
+	_, err := readSudoPayload(handle, "run", wrongKey, false)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want the payload to not be found", err)
+	}
+
+	// Even when it is, the signature doesn't match
+	err = os.Rename(sudoChannelFile(handle, key, sudoPayloadFilename), sudoChannelFile(handle, wrongKey, sudoPayloadFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readSudoPayload(handle, "run", wrongKey, false)
+	if err == nil {
+		t.Error("payload signed with another key was accepted")
+	}
+}
+
+func TestSudoPayloadWrongAction(t *testing.T) {
+	clearInvokingUser(t)
+
+	key := newTestSudoKey(t)
+	handle := writeSudoPayloadForTest(t, newTestSudoPayload(), key)
+
+	_, err := readSudoPayload(handle, sudoHelperActionName, key, false)
+	if err == nil {
+		t.Error("payload for another action was accepted")
+	}
+}
+
+func TestSudoPayloadWrongChannel(t *testing.T) {
+	clearInvokingUser(t)
+
+	key := newTestSudoKey(t)
+	payload := newTestSudoPayload()
+	payload.IssuedAt = time.Now().Unix()
+	payload.User = "deploy"
+
+	// A payload for another user in a private channel
+	handle := writeTestSudoPayload(t, payload, key)
+
+	_, err := readSudoPayload(handle, "run", key, false)
+	if err == nil {
+		t.Error("payload for another user was accepted from a private channel")
+	}
+}
+
+func TestSudoPayloadExpired(t *testing.T) {
+	clearInvokingUser(t)
+
+	tests := map[string]time.Duration{
+		"old":    -sudoPayloadMaxAge - time.Minute,
+		"future": 2 * time.Minute,
+	}
+
+	for name, offset := range tests {
+		t.Run(name, func(t *testing.T) {
+			key := newTestSudoKey(t)
+			payload := newTestSudoPayload()
+			payload.IssuedAt = time.Now().Add(offset).Unix()
+
+			handle := writeTestSudoPayload(t, payload, key)
+
+			_, err := readSudoPayload(handle, "run", key, false)
+			if err == nil {
+				t.Error("expired payload was accepted")
+			}
+		})
+	}
+
+	key := newTestSudoKey(t)
+	payload := newTestSudoPayload()
+	payload.IssuedAt = time.Now().Add(-sudoPayloadMaxAge + time.Minute).Unix()
+
+	handle := writeTestSudoPayload(t, payload, key)
+
+	_, err := readSudoPayload(handle, "run", key, false)
+	if err != nil {
+		t.Errorf("payload within its max age was rejected: %v", err)
+	}
+}
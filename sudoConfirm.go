@@ -0,0 +1,80 @@
+package clicommon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/mattn/go-isatty"
+)
+
+// ErrSudoDeclined is returned when the user declines to give superuser
+// permissions after seeing what they're needed for
+var ErrSudoDeclined = errors.New("superuser permissions declined")
+
+// DescribedSudoAction is a SudoAction or TypedSudoAction that can describe what
+// it does in a short human-readable sentence, like "Write /etc/hosts". It's
+// shown to the user before they're asked for superuser permissions.
+type DescribedSudoAction interface {
+	Describe() string
+}
+
+var (
+	sudoConfirmLock sync.Mutex
+	sudoAssumeYes   bool
+)
+
+// SetSudoAssumeYes turns off asking the user to confirm sudo actions before
+// requesting superuser permissions, e.g. for a --yes flag or when running
+// non-interactively. Confirmation is also skipped when stdin isn't a terminal
+// or the process already has superuser permissions.
+func SetSudoAssumeYes(yes bool) {
+	sudoConfirmLock.Lock()
+	defer sudoConfirmLock.Unlock()
+
+	sudoAssumeYes = yes
+}
+
+// confirmSudo shows the user what superuser permissions are needed for and asks
+// them to confirm, unless that's been turned off or isn't possible
+func confirmSudo(descriptions []string) error {
+	sudoConfirmLock.Lock()
+	assumeYes := sudoAssumeYes
+	sudoConfirmLock.Unlock()
+
+	if assumeYes || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return nil
+	}
+
+	if escalator, err := DetectEscalator(); err == nil {
+		if _, ok := escalator.(alreadyElevatedEscalator); ok {
+			return nil
+		}
+	}
+
+	fmt.Println("Superuser permissions are needed to:")
+
+	for _, description := range descriptions {
+		fmt.Printf("  - %s\n", description)
+	}
+
+	if !CliQuestionYesNoDefault("Continue?", true) {
+		return ErrSudoDeclined
+	}
+
+	return nil
+}
+
+// describeSudoAction gets the description of an action for confirmation,
+// falling back to its name and redacted params
+func describeSudoAction(action AnySudoAction, step *sudoStep) string {
+	if described, ok := action.(DescribedSudoAction); ok {
+		return Redact(described.Describe())
+	}
+
+	params, _ := json.Marshal(redactSudoStep(step))
+
+	return Redact(fmt.Sprintf("Run %s %s", action.Name(), params))
+}
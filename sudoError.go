@@ -0,0 +1,96 @@
+package clicommon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Codes for SudoErrors created from common os package errors, so errors.Is
+// still works with those errors after they're sent back from the elevated
+// process
+const (
+	SudoErrorNotExist   = "not_exist"
+	SudoErrorExist      = "exist"
+	SudoErrorPermission = "permission"
+)
+
+var sudoErrorTargets = map[string]error{
+	SudoErrorNotExist:   os.ErrNotExist,
+	SudoErrorExist:      os.ErrExist,
+	SudoErrorPermission: os.ErrPermission,
+}
+
+// SudoError is an error from a sudo action, sent back from the elevated process
+// to CallSudo or CallSudoTyped. Actions can return a SudoError themselves to
+// set a Code the caller can check, otherwise any returned error is converted
+// into one, including the chain of errors it wraps.
+type SudoError struct {
+	Message string     `json:"message"`
+	Code    string     `json:"code,omitempty"`
+	Cause   *SudoError `json:"cause,omitempty"`
+}
+
+func (e *SudoError) Error() string {
+	return e.Message
+}
+
+func (e *SudoError) Unwrap() error {
+	if e.Cause == nil {
+		return nil
+	}
+
+	return e.Cause
+}
+
+// Is reports if the error's code corresponds to target, like os.ErrNotExist
+func (e *SudoError) Is(target error) bool {
+	codeTarget, ok := sudoErrorTargets[e.Code]
+
+	return ok && codeTarget == target
+}
+
+// ErrSudoProtocolMismatch is returned by HandleSudo when it's called by a
+// different version of this program that passes sudo calls differently
+var ErrSudoProtocolMismatch = errors.New("sudo call is from an incompatible version of this program")
+
+// SudoExitError is returned when the elevated process exits without sending a
+// response, with the exit code it exited with
+type SudoExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *SudoExitError) Error() string {
+	return fmt.Sprintf("sudo action exited with code %d: %v", e.Code, e.Err)
+}
+
+func (e *SudoExitError) Unwrap() error {
+	return e.Err
+}
+
+func toSudoError(err error) *SudoError {
+	if err == nil {
+		return nil
+	}
+
+	if sudoErr, ok := err.(*SudoError); ok {
+		return sudoErr
+	}
+
+	sudoErr := &SudoError{
+		Message: err.Error(),
+		Cause:   toSudoError(errors.Unwrap(err)),
+	}
+
+	if sudoErr.Cause == nil {
+		for code, target := range sudoErrorTargets {
+			if errors.Is(err, target) {
+				sudoErr.Code = code
+				break
+			}
+		}
+	}
+
+	return sudoErr
+}
@@ -0,0 +1,368 @@
+package clicommon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kardianos/osext"
+)
+
+const (
+	sudoHelperActionName = "helper"
+
+	defaultSudoHelperIdleTimeout = 5 * time.Minute
+	sudoHelperCloseTimeout       = 5 * time.Second
+
+	defaultSudoHelperDescription = "Run privileged actions until this command finishes"
+)
+
+// ErrSudoHelperClosed is returned when calling a helper that has been closed or
+// has exited, e.g. because it was idle for too long
+var ErrSudoHelperClosed = errors.New("sudo helper has exited")
+
+// SudoHelperOptions configures StartSudoHelper
+type SudoHelperOptions struct {
+	// IdleTimeout is how long the helper waits for another action before
+	// exiting by itself. Defaults to 5 minutes.
+	IdleTimeout time.Duration
+
+	// Description is shown to the user when confirming that the helper may be
+	// started. Defaults to a generic description, since the actions aren't
+	// known yet.
+	Description string
+}
+
+// SudoHelper is an elevated copy of this program that keeps running actions
+// for the process that started it until it's closed, so superuser permissions
+// only have to be requested once
+type SudoHelper struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	decoder *json.Decoder
+	nextID  int
+
+	requests  *os.File
+	responses *os.File
+	closeOnce sync.Once
+	cancel    context.CancelFunc
+	done      chan struct{}
+	exitErr   error
+
+	// dryRun is set for helpers started in dry-run mode, which don't have a
+	// process and only print actions
+	dryRun bool
+}
+
+type sudoHelperRequest struct {
+	ID   int       `json:"id"`
+	Step *sudoStep `json:"step"`
+}
+
+type sudoHelperResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *SudoError      `json:"error,omitempty"`
+}
+
+// StartSudoHelper asks the user for superuser permissions once and starts an
+// elevated helper process that runs actions sent to it with Call. Requests and
+// responses go over the helper's stdin and stdout, so anything actions print
+// to stdout ends up on stderr instead. Escalators that can't connect to the
+// elevated process's stdin and stdout, like a UAC prompt, aren't supported.
+func StartSudoHelper(options SudoHelperOptions) (*SudoHelper, error) {
+	if IsSudoDryRun() {
+		return &SudoHelper{dryRun: true}, nil
+	}
+
+	if options.Description == "" {
+		options.Description = defaultSudoHelperDescription
+	}
+
+	err := confirmSudo([]string{options.Description})
+	if err != nil {
+		return nil, err
+	}
+
+	escalator, err := DetectEscalator()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := escalator.(detachedEscalator); ok {
+		return nil, errors.New("sudo helper is not supported by the " + escalator.Name() + " escalator")
+	}
+
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = defaultSudoHelperIdleTimeout
+	}
+
+	thisExe, err := osext.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newSudoKey()
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := writeSudoPayload(&sudoPayload{
+		Action:      sudoHelperActionName,
+		IdleTimeout: options.IdleTimeout,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &EscalationRequest{
+		Executable: thisExe,
+		Args:       sudoArgs(sudoHelperActionName, handle),
+		Stderr:     os.Stderr,
+	}
+
+	err = sendSudoKey(request, handle, key)
+	if err != nil {
+		os.RemoveAll(handle)
+		return nil, err
+	}
+
+	helper, err := startSudoHelper(escalator, request, handle)
+	if err != nil {
+		os.RemoveAll(handle)
+		return nil, err
+	}
+
+	return helper, nil
+}
+
+func startSudoHelper(escalator Escalator, request *EscalationRequest, handle string) (*SudoHelper, error) {
+	requestsReader, requestsWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	responsesReader, responsesWriter, err := os.Pipe()
+	if err != nil {
+		requestsReader.Close()
+		requestsWriter.Close()
+		return nil, err
+	}
+
+	// Anything the escalator needs to send first, like the key, goes ahead of
+	// the requests
+	if request.Stdin != nil {
+		_, err = io.Copy(requestsWriter, request.Stdin)
+		if err != nil {
+			requestsReader.Close()
+			requestsWriter.Close()
+			responsesReader.Close()
+			responsesWriter.Close()
+			return nil, err
+		}
+	}
+
+	request.Stdin = requestsReader
+	request.Stdout = responsesWriter
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	helper := &SudoHelper{
+		encoder:   json.NewEncoder(requestsWriter),
+		decoder:   json.NewDecoder(responsesReader),
+		requests:  requestsWriter,
+		responses: responsesReader,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(helper.done)
+
+		helper.exitErr = escalator.Run(ctx, request)
+
+		// Let any waiting Call see that the helper is gone
+		requestsReader.Close()
+		responsesWriter.Close()
+		os.RemoveAll(handle)
+	}()
+
+	return helper, nil
+}
+
+// Call runs a SudoAction or TypedSudoAction in the helper, decoding a typed
+// action's result into result unless it's nil. Calls are run one at a time.
+func (helper *SudoHelper) Call(action AnySudoAction, result interface{}) error {
+	step, err := encodeSudoStep(action)
+	if err != nil {
+		return err
+	}
+
+	if helper.dryRun {
+		printSudoDryRun([]*sudoStep{step}, "")
+		return nil
+	}
+
+	helper.mu.Lock()
+	defer helper.mu.Unlock()
+
+	select {
+	case <-helper.done:
+		return ErrSudoHelperClosed
+	default:
+	}
+
+	helper.nextID++
+	id := helper.nextID
+
+	err = helper.encoder.Encode(&sudoHelperRequest{
+		ID:   id,
+		Step: step,
+	})
+	if err != nil {
+		return ErrSudoHelperClosed
+	}
+
+	response := sudoHelperResponse{}
+
+	err = helper.decoder.Decode(&response)
+	if err != nil {
+		auditSudoSteps(&sudoPayload{Steps: []*sudoStep{step}}, nil, ErrSudoHelperClosed)
+		recordSudoSteps(&sudoPayload{Steps: []*sudoStep{step}}, nil, ErrSudoHelperClosed)
+		return ErrSudoHelperClosed
+	}
+
+	if response.ID != id {
+		return errors.New("sudo helper responded to the wrong request")
+	}
+
+	stepResponse := &sudoResponse{Error: response.Error}
+	if response.Error == nil {
+		stepResponse.Results = []json.RawMessage{response.Result}
+	}
+
+	auditSudoSteps(&sudoPayload{Steps: []*sudoStep{step}}, stepResponse, nil)
+	recordSudoSteps(&sudoPayload{Steps: []*sudoStep{step}}, stepResponse, nil)
+
+	if response.Error != nil {
+		return response.Error
+	}
+
+	if result != nil && len(response.Result) > 0 {
+		return json.Unmarshal(response.Result, result)
+	}
+
+	return nil
+}
+
+// Close tells the helper to exit and waits for it, killing it if it doesn't
+// exit in time
+func (helper *SudoHelper) Close() error {
+	if helper.dryRun {
+		return nil
+	}
+
+	// The helper exits once it reaches the end of its stdin
+	helper.closeOnce.Do(func() {
+		helper.requests.Close()
+	})
+
+	select {
+	case <-helper.done:
+	case <-time.After(sudoHelperCloseTimeout):
+		helper.cancel()
+		<-helper.done
+	}
+
+	helper.cancel()
+	helper.responses.Close()
+
+	return helper.exitErr
+}
+
+// serveSudoHelper runs actions from stdin and writes their results to stdout,
+// until stdin is closed or no actions have been requested for too long
+func serveSudoHelper(payload *sudoPayload) error {
+	in := os.Stdin
+	out := os.Stdout
+
+	// Keep actions from printing into the responses
+	os.Stdout = os.Stderr
+
+	type decoded struct {
+		request sudoHelperRequest
+		err     error
+	}
+
+	requests := make(chan decoded)
+
+	go func() {
+		decoder := json.NewDecoder(in)
+
+		for {
+			var next decoded
+			next.err = decoder.Decode(&next.request)
+
+			requests <- next
+
+			if next.err != nil {
+				return
+			}
+		}
+	}()
+
+	idleTimeout := payload.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultSudoHelperIdleTimeout
+	}
+
+	encoder := json.NewEncoder(out)
+	timer := time.NewTimer(idleTimeout)
+
+	for {
+		select {
+		case <-timer.C:
+			Log.Debug("Sudo helper idle, exiting")
+			return nil
+
+		case next := <-requests:
+			if next.err == io.EOF {
+				return nil
+			} else if next.err != nil {
+				return next.err
+			}
+
+			response := sudoHelperResponse{
+				ID: next.request.ID,
+			}
+
+			result, err := runSudoStep(next.request.Step)
+			if err == nil {
+				response.Result, err = json.Marshal(result)
+			}
+
+			response.Error = toSudoError(err)
+
+			stepResponse := &sudoResponse{Error: response.Error}
+			if response.Error == nil {
+				stepResponse.Results = []json.RawMessage{response.Result}
+			}
+
+			auditElevatedSudoSteps(&sudoPayload{Steps: []*sudoStep{next.request.Step}}, stepResponse)
+
+			err = encoder.Encode(&response)
+			if err != nil {
+				return err
+			}
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(idleTimeout)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package clicommon
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// InvokingUserIDs gets the user and group IDs of the user that requested the
+// running sudo action, from the environment variables set by sudo, doas or
+// pkexec. Outside of a sudo action these are the current user's IDs. Both are
+// -1 on Windows, or if they're unknown.
+func InvokingUserIDs() (uid, gid int) {
+	return invokingIDs()
+}
+
+// LookupInvokingUser gets the user that requested the running sudo action, or
+// the current user outside of a sudo action
+func LookupInvokingUser() (*user.User, error) {
+	uid, _ := invokingIDs()
+	if uid == -1 || uid == os.Getuid() {
+		return user.Current()
+	}
+
+	return user.LookupId(strconv.Itoa(uid))
+}
+
+// isElevatedForInvoker reports if this process runs as root for a different
+// user that requested it, so files it creates for that user need their owner
+// changed. Actions run as another user by CallSudoAs can't do that, so they're
+// treated like that user's own process.
+func isElevatedForInvoker() bool {
+	uid, _ := invokingIDs()
+
+	return uid != -1 && uid != os.Getuid() && os.Geteuid() == 0
+}
+
+// ChownToInvokingUser makes the user that requested the running sudo action
+// own the file or directory at path, without following symlinks. It does
+// nothing outside of a sudo action.
+func ChownToInvokingUser(path string) error {
+	if !isElevatedForInvoker() {
+		return nil
+	}
+
+	uid, gid := invokingIDs()
+
+	return os.Lchown(path, uid, gid)
+}
+
+// MkdirAllAsInvokingUser is like os.MkdirAll, but every directory it creates is
+// owned by the user that requested the running sudo action. The closest
+// existing parent directory must already be owned by that user, so a symlink
+// can't redirect the new directories somewhere only root could create them.
+func MkdirAllAsInvokingUser(path string, perm os.FileMode) error {
+	if !isElevatedForInvoker() {
+		return os.MkdirAll(path, perm)
+	}
+
+	path = filepath.Clean(path)
+
+	var missing []string
+
+	existing := path
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		missing = append(missing, existing)
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+
+		existing = parent
+	}
+
+	if len(missing) == 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: errors.New("not a directory")}
+		}
+
+		return nil
+	}
+
+	err := checkInvokerOwnsDir(existing)
+	if err != nil {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		err = os.Mkdir(missing[i], perm)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		err = ChownToInvokingUser(missing[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteFileAsInvokingUser is like ioutil.WriteFile, but the file is owned by
+// the user that requested the running sudo action. The file is replaced
+// instead of written through, so a symlink in its place can't redirect the
+// write, and its directory must already be owned by that user.
+func WriteFileAsInvokingUser(filename string, data []byte, perm os.FileMode) error {
+	if !isElevatedForInvoker() {
+		return ioutil.WriteFile(filename, data, perm)
+	}
+
+	err := checkInvokerOwnsDir(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+
+	uid, gid := invokingIDs()
+
+	return writeFileAtomic(filename, bytes.NewReader(data), perm, uid, gid)
+}
+
+// OpenInvokingUserFile opens a regular file owned by the user that requested the
+// running sudo action for reading, e.g. one they passed as a param. Symlinks
+// aren't followed, and the checks are done on the opened file rather than its
+// path, so the file can't be swapped for a link to a file only root can read
+// between checking and opening it.
+func OpenInvokingUserFile(path string) (*os.File, error) {
+	file, err := openNoFollow(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = &os.PathError{Op: "open", Path: path, Err: errors.New("not a regular file")}
+	}
+	if err == nil {
+		err = checkOwnedByInvoker(info)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// checkInvokerOwnsDir makes sure a directory, after following any symlinks to
+// it, is owned by the user that requested the running sudo action
+func checkInvokerOwnsDir(dir string) error {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+	}
+
+	return checkOwnedByInvoker(info)
+}
@@ -0,0 +1,119 @@
+package clicommon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultSudoKeepaliveInterval is well below sudo's default timestamp_timeout
+// of 5 minutes, so a refresh that fails once can be retried in time
+const defaultSudoKeepaliveInterval = time.Minute
+
+// SudoKeepaliveOptions configures StartSudoKeepalive
+type SudoKeepaliveOptions struct {
+	// Interval is how often the user's cached password is refreshed, a minute
+	// by default. It must be shorter than how long the escalator caches it.
+	Interval time.Duration
+}
+
+// SudoKeepalive keeps the password the user gave the escalator cached, so sudo
+// calls minutes apart don't ask for it again. It's created by
+// StartSudoKeepalive and must be closed when the operation that needed it is
+// done.
+type SudoKeepalive struct {
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// StartSudoKeepalive asks the user for their password once, like sudo -v, and
+// then refreshes it in the background until the keepalive is closed, so long
+// operations that call CallSudo several times only ask for it once. Refreshing
+// never asks for the password again, and only happens inside of this process,
+// so it stops when the process exits as well.
+//
+// Escalators that don't cache passwords, like pkexec or a UAC prompt, and
+// processes that already have superuser permissions don't need a keepalive,
+// so it doesn't do anything for them, and neither does it in dry-run mode.
+func StartSudoKeepalive(options SudoKeepaliveOptions) (*SudoKeepalive, error) {
+	keepalive := &SudoKeepalive{
+		cancel: func() {},
+		done:   make(chan struct{}),
+	}
+
+	if IsSudoDryRun() {
+		close(keepalive.done)
+		return keepalive, nil
+	}
+
+	escalator, err := DetectEscalator()
+	if err != nil {
+		return nil, err
+	}
+
+	caching, ok := escalator.(credentialCachingEscalator)
+	if !ok || !caching.cachesCredentials() {
+		Log.Debug("Escalator doesn't cache credentials, not keeping them alive", "escalator", escalator.Name())
+
+		close(keepalive.done)
+		return keepalive, nil
+	}
+
+	if options.Interval <= 0 {
+		options.Interval = defaultSudoKeepaliveInterval
+	}
+
+	err = caching.validateCredentials(context.Background(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	keepalive.cancel = cancel
+
+	go keepalive.run(ctx, caching, options.Interval)
+
+	return keepalive, nil
+}
+
+// WithSudoKeepalive runs fn with a SudoKeepalive started for as long as it runs,
+// and returns fn's error
+func WithSudoKeepalive(options SudoKeepaliveOptions, fn func() error) error {
+	keepalive, err := StartSudoKeepalive(options)
+	if err != nil {
+		return err
+	}
+	defer keepalive.Close()
+
+	return fn()
+}
+
+func (keepalive *SudoKeepalive) run(ctx context.Context, caching credentialCachingEscalator, interval time.Duration) {
+	defer close(keepalive.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			err := caching.validateCredentials(ctx, false)
+			if err != nil && ctx.Err() == nil {
+				Log.Warn("Error refreshing cached superuser credentials", "error", err)
+			}
+		}
+	}
+}
+
+// Close stops refreshing the user's cached password, and waits for a refresh
+// that's running to stop. The password stays cached until it expires as usual.
+func (keepalive *SudoKeepalive) Close() error {
+	keepalive.closeOnce.Do(keepalive.cancel)
+	<-keepalive.done
+
+	return nil
+}
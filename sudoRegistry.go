@@ -0,0 +1,160 @@
+package clicommon
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// actionNameRegex matches valid action names, which are one or more words
+// separated by dots, the first ones being the namespace
+var actionNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+var (
+	registryLock           sync.RWMutex
+	registeredActions      = map[string]SudoAction{}
+	registeredTypedActions = map[string]reflect.Type{}
+
+	// reservedActionNames are used by this package for payloads that aren't
+	// a single action
+	reservedActionNames = map[string]bool{
+		sudoBatchActionName:  true,
+		sudoHelperActionName: true,
+	}
+)
+
+// SudoActionInfo describes a registered action, see ListActions
+type SudoActionInfo struct {
+	// Name is the action's full name, including its namespace
+	Name string
+
+	// Namespace is the part of the name before the last dot, if any
+	Namespace string
+
+	// Type is the Go type the action was registered with
+	Type string
+
+	// Typed is set for a TypedSudoAction, and unset for a SudoAction
+	Typed bool
+
+	// Params is the action's schema, if it has one
+	Params []SudoParam
+}
+
+// ActionName builds a namespaced action name, like "mylib.installService".
+// Libraries that register their own actions should namespace their names, so
+// they can't conflict with the actions of the program using them.
+func ActionName(namespace, name string) string {
+	return namespace + "." + name
+}
+
+// RegisterAction registers a SudoAction so the elevated process can run it. It
+// panics if the name is invalid or already taken by an action of another type.
+func RegisterAction(action SudoAction) {
+	registerAction(action.Name(), reflect.TypeOf(action), func() {
+		registeredActions[action.Name()] = action
+	})
+}
+
+// RegisterTypedAction registers a TypedSudoAction so the elevated process can
+// decode and run it. The registered value is only used for its type. It panics
+// if the name is invalid or already taken by an action of another type.
+func RegisterTypedAction(action TypedSudoAction) {
+	registerAction(action.Name(), reflect.TypeOf(action), func() {
+		registeredTypedActions[action.Name()] = reflect.TypeOf(action)
+	})
+}
+
+func registerAction(name string, actionType reflect.Type, register func()) {
+	if !actionNameRegex.MatchString(name) {
+		panic(fmt.Sprintf("clicommon: invalid sudo action name %q for %s", name, actionType))
+	}
+
+	if reservedActionNames[name] {
+		panic(fmt.Sprintf("clicommon: sudo action name %q for %s is reserved", name, actionType))
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	var existing reflect.Type
+
+	if handler, ok := registeredActions[name]; ok {
+		existing = reflect.TypeOf(handler)
+	} else if typed, ok := registeredTypedActions[name]; ok {
+		existing = typed
+	}
+
+	if existing != nil {
+		if existing == actionType {
+			return
+		}
+
+		panic(fmt.Sprintf("clicommon: sudo action %q registered by both %s and %s", name, existing, actionType))
+	}
+
+	register()
+}
+
+// lookupAction gets a registered action by name, which is either a SudoAction
+// or the type of a TypedSudoAction, or neither if there's no such action
+func lookupAction(name string) (SudoAction, reflect.Type) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return registeredActions[name], registeredTypedActions[name]
+}
+
+// ListActions lists every registered action sorted by name, e.g. for help
+// output or diagnostics
+func ListActions() []SudoActionInfo {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	var infos []SudoActionInfo
+
+	for name, handler := range registeredActions {
+		info := newSudoActionInfo(name, reflect.TypeOf(handler))
+
+		if schemaAction, ok := handler.(SchemaSudoAction); ok {
+			info.Params = schemaAction.ParamSchema()
+		}
+
+		infos = append(infos, info)
+	}
+
+	for name, actionType := range registeredTypedActions {
+		info := newSudoActionInfo(name, actionType)
+		info.Typed = true
+
+		if action, err := decodeTypedAction(actionType, nil); err == nil {
+			if schemaAction, ok := action.(SchemaSudoAction); ok {
+				info.Params = schemaAction.ParamSchema()
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
+
+func newSudoActionInfo(name string, actionType reflect.Type) SudoActionInfo {
+	info := SudoActionInfo{
+		Name: name,
+		Type: actionType.String(),
+	}
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		info.Namespace = name[:i]
+	}
+
+	return info
+}
@@ -0,0 +1,71 @@
+package clicommon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// CallSudoAs runs a SudoAction or TypedSudoAction as another user instead of
+// root, like a service account, through the escalator's option for that, e.g.
+// sudo -u. It's otherwise the same as CallSudoContext, including how the action
+// is found in the registry and its result is sent back. The user has to exist,
+// and the escalator has to implement UserEscalator.
+//
+// Since the action doesn't run as root, the private directory its params are
+// passed through can't be private to the invoking user. Instead the user it
+// runs as can enter that directory without listing it, and the files in it are
+// named after the one-time key, so nobody else can find them.
+func CallSudoAs(username string, action AnySudoAction, result interface{}) error {
+	return CallSudoContext(context.Background(), action, result, SudoCallOptions{User: username})
+}
+
+// checkRunAsUser makes sure a sudo call can run as the given user, before the
+// user is asked for any permissions
+func checkRunAsUser(username string) error {
+	_, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("can't run sudo action as %s: %w", username, err)
+	}
+
+	if IsSudoDryRun() {
+		return nil
+	}
+
+	escalator, err := DetectEscalator()
+	if err != nil {
+		return err
+	}
+
+	if userEscalator, ok := escalator.(UserEscalator); !ok || !userEscalator.SupportsUser() {
+		return fmt.Errorf("the %s escalator can't run sudo actions as another user", escalator.Name())
+	}
+
+	return nil
+}
+
+// checkRunningAsUser makes sure the elevated process runs as the user a payload
+// is meant to run as, in case an escalator ignored it. Running as the invoking
+// user is fine as well, since that's no escalation at all, like in tests.
+func checkRunningAsUser(username string) error {
+	if username == "" {
+		return nil
+	}
+
+	if uid, _ := invokingIDs(); uid == -1 || uid == os.Getuid() {
+		return nil
+	}
+
+	target, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+
+	if target.Uid != strconv.Itoa(os.Getuid()) {
+		return fmt.Errorf("sudo action should run as %s, but runs as %s", username, currentUsername())
+	}
+
+	return nil
+}
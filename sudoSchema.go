@@ -0,0 +1,267 @@
+package clicommon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SudoErrorInvalidParams is the code of errors from actions whose params didn't
+// match their schema
+const SudoErrorInvalidParams = "invalid_params"
+
+// ErrInvalidSudoParams matches any error from an action whose params didn't
+// match its schema, with errors.Is
+var ErrInvalidSudoParams = errors.New("invalid sudo action parameters")
+
+func init() {
+	sudoErrorTargets[SudoErrorInvalidParams] = ErrInvalidSudoParams
+}
+
+// SudoParamType is the type a sudo action param must have
+type SudoParamType int
+
+const (
+	SudoParamString SudoParamType = iota
+	SudoParamInt
+	SudoParamBool
+
+	// SudoParamPath is a string that must be an absolute path, since escalators
+	// like pkexec don't keep the working directory
+	SudoParamPath
+)
+
+func (t SudoParamType) String() string {
+	switch t {
+	case SudoParamString:
+		return "string"
+	case SudoParamInt:
+		return "int"
+	case SudoParamBool:
+		return "bool"
+	case SudoParamPath:
+		return "path"
+	default:
+		return "unknown"
+	}
+}
+
+// SudoParam describes a single param of a sudo action. The path constraints are
+// only checked for SudoParamPath params, without following symlinks. They're
+// checked before the action runs, so the file at the path can be replaced in
+// the meantime. Actions that read a file given by the invoking user have to
+// open it with OpenInvokingUserFile, which checks the opened file itself.
+type SudoParam struct {
+	Name     string
+	Type     SudoParamType
+	Required bool
+
+	// Secret keeps the param out of the audit log and dry-run output
+	Secret bool
+
+	// RegularFile requires the path to be an existing regular file
+	RegularFile bool
+
+	// Directory requires the path to be an existing directory
+	Directory bool
+
+	// OwnedByInvoker requires the path to exist and be owned by the user that
+	// requested the sudo action
+	OwnedByInvoker bool
+
+	// UnderTempDir requires the path to be inside the elevated process's
+	// temporary directory. Some escalators reset TMPDIR, so this is only
+	// reliable for paths under a fixed directory like /tmp.
+	UnderTempDir bool
+}
+
+// SchemaSudoAction is a SudoAction or TypedSudoAction that declares its params,
+// so they're validated in the elevated process before the action runs. A
+// SudoAction's params are matched to the schema by position, and it can't get
+// more params than the schema has. A TypedSudoAction's params are matched to
+// its fields by Go field name, and a required field must not be the zero value.
+type SchemaSudoAction interface {
+	ParamSchema() []SudoParam
+}
+
+// validateSudoParams checks a SudoAction's params against its schema, if it has
+// one
+func validateSudoParams(action SudoAction, params []string) error {
+	schemaAction, ok := action.(SchemaSudoAction)
+	if !ok {
+		return nil
+	}
+
+	schema := schemaAction.ParamSchema()
+
+	if len(params) > len(schema) {
+		return invalidSudoParams(action.Name(), "", fmt.Sprintf("expected at most %d parameters, got %d", len(schema), len(params)), nil)
+	}
+
+	for i, param := range schema {
+		if i >= len(params) || params[i] == "" {
+			if param.Required {
+				return invalidSudoParams(action.Name(), param.Name, "is required", nil)
+			}
+
+			continue
+		}
+
+		value := params[i]
+
+		switch param.Type {
+		case SudoParamInt:
+			_, err := strconv.Atoi(value)
+			if err != nil {
+				return invalidSudoParams(action.Name(), param.Name, "must be an int", nil)
+			}
+
+		case SudoParamBool:
+			_, err := strconv.ParseBool(value)
+			if err != nil {
+				return invalidSudoParams(action.Name(), param.Name, "must be a bool", nil)
+			}
+
+		case SudoParamPath:
+			err := validateSudoPath(action.Name(), param, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateTypedSudoParams checks a decoded TypedSudoAction's fields against its
+// schema, if it has one
+func validateTypedSudoParams(action TypedSudoAction) error {
+	schemaAction, ok := action.(SchemaSudoAction)
+	if !ok {
+		return nil
+	}
+
+	value := reflect.ValueOf(action)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			// Its Name method may not work on a nil pointer
+			return invalidSudoParams(reflect.TypeOf(action).String(), "", "action is nil", nil)
+		}
+
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("sudo action %s has a param schema but isn't a struct", action.Name())
+	}
+
+	for _, param := range schemaAction.ParamSchema() {
+		field := value.FieldByName(param.Name)
+		if !field.IsValid() {
+			return fmt.Errorf("sudo action %s has no field %s from its param schema", action.Name(), param.Name)
+		}
+
+		kindOk := false
+
+		switch param.Type {
+		case SudoParamString, SudoParamPath:
+			kindOk = field.Kind() == reflect.String
+		case SudoParamInt:
+			switch field.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				kindOk = true
+			}
+		case SudoParamBool:
+			kindOk = field.Kind() == reflect.Bool
+		}
+
+		if !kindOk {
+			return fmt.Errorf("field %s of sudo action %s is a %s, not a %s", param.Name, action.Name(), field.Type(), param.Type)
+		}
+
+		if field.IsZero() {
+			if param.Required {
+				return invalidSudoParams(action.Name(), param.Name, "is required", nil)
+			}
+
+			continue
+		}
+
+		if param.Type == SudoParamPath {
+			err := validateSudoPath(action.Name(), param, field.String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateSudoPath(action string, param SudoParam, path string) error {
+	if !filepath.IsAbs(path) {
+		return invalidSudoParams(action, param.Name, "must be an absolute path", nil)
+	}
+
+	if param.UnderTempDir {
+		// Resolve symlinks in the parent directories, so a link inside the
+		// temporary directory can't point out of it
+		tempDir, err := filepath.EvalSymlinks(os.TempDir())
+		if err != nil {
+			return err
+		}
+
+		dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return invalidSudoParams(action, param.Name, "must be in the temporary directory", err)
+		}
+
+		rel, err := filepath.Rel(tempDir, filepath.Join(dir, filepath.Base(path)))
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return invalidSudoParams(action, param.Name, "must be in the temporary directory", nil)
+		}
+	}
+
+	if !param.RegularFile && !param.Directory && !param.OwnedByInvoker {
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return invalidSudoParams(action, param.Name, "must exist", err)
+	}
+
+	if param.RegularFile && !info.Mode().IsRegular() {
+		return invalidSudoParams(action, param.Name, "must be a regular file", nil)
+	}
+
+	if param.Directory && !info.IsDir() {
+		return invalidSudoParams(action, param.Name, "must be a directory", nil)
+	}
+
+	if param.OwnedByInvoker {
+		err = checkOwnedByInvoker(info)
+		if err != nil {
+			return invalidSudoParams(action, param.Name, "must be owned by the invoking user", err)
+		}
+	}
+
+	return nil
+}
+
+func invalidSudoParams(action, param, message string, cause error) *SudoError {
+	if param != "" {
+		message = fmt.Sprintf("parameter %s %s", param, message)
+	}
+
+	return &SudoError{
+		Message: fmt.Sprintf("invalid parameters for sudo action %s: %s", action, message),
+		Code:    SudoErrorInvalidParams,
+		Cause:   toSudoError(cause),
+	}
+}
@@ -0,0 +1,265 @@
+//go:build !windows
+// +build !windows
+
+package clicommon
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type schemaTestAction struct {
+	schema []SudoParam
+}
+
+func (a schemaTestAction) Name() string             { return "test.schema" }
+func (a schemaTestAction) Params() []string         { return nil }
+func (a schemaTestAction) Handle([]string) error    { return nil }
+func (a schemaTestAction) ParamSchema() []SudoParam { return a.schema }
+
+type schemaTestTypedAction struct {
+	Path  string
+	Count int
+	Force bool
+	Note  string
+}
+
+func (a schemaTestTypedAction) Name() string              { return "test.typedSchema" }
+func (a schemaTestTypedAction) Run() (interface{}, error) { return nil, nil }
+
+func (a schemaTestTypedAction) ParamSchema() []SudoParam {
+	return []SudoParam{
+		{Name: "Path", Type: SudoParamPath, Required: true},
+		{Name: "Count", Type: SudoParamInt},
+		{Name: "Force", Type: SudoParamBool},
+		{Name: "Note", Type: SudoParamString},
+	}
+}
+
+type schemaTestWrongKindAction struct {
+	Count string
+}
+
+func (a schemaTestWrongKindAction) Name() string              { return "test.wrongKind" }
+func (a schemaTestWrongKindAction) Run() (interface{}, error) { return nil, nil }
+
+func (a schemaTestWrongKindAction) ParamSchema() []SudoParam {
+	return []SudoParam{{Name: "Count", Type: SudoParamInt}}
+}
+
+// setTempDir makes dir the temporary directory for the test
+func setTempDir(t *testing.T, dir string) {
+	previous, ok := os.LookupEnv("TMPDIR")
+
+	os.Setenv("TMPDIR", dir)
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv("TMPDIR", previous)
+		} else {
+			os.Unsetenv("TMPDIR")
+		}
+	})
+}
+
+func TestValidateSudoParams(t *testing.T) {
+	schema := []SudoParam{
+		{Name: "Name", Type: SudoParamString, Required: true},
+		{Name: "Count", Type: SudoParamInt},
+		{Name: "Force", Type: SudoParamBool},
+		{Name: "Path", Type: SudoParamPath},
+	}
+
+	tests := []struct {
+		name   string
+		params []string
+		valid  bool
+	}{
+		{"all", []string{"a", "3", "true", "/etc/hosts"}, true},
+		{"only required", []string{"a"}, true},
+		{"empty optional", []string{"a", "", "", ""}, true},
+		{"negative int", []string{"a", "-1"}, true},
+		{"missing required", nil, false},
+		{"empty required", []string{"", "3"}, false},
+		{"too many", []string{"a", "3", "true", "/etc/hosts", "extra"}, false},
+		{"invalid int", []string{"a", "three"}, false},
+		{"float int", []string{"a", "1.5"}, false},
+		{"invalid bool", []string{"a", "3", "yes"}, false},
+		{"relative path", []string{"a", "3", "true", "etc/hosts"}, false},
+		{"dot path", []string{"a", "3", "true", "./hosts"}, false},
+	}
+
+	for _, test := range tests {
+		err := validateSudoParams(schemaTestAction{schema: schema}, test.params)
+
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.valid && !errors.Is(err, ErrInvalidSudoParams) {
+			t.Errorf("%s: got %v, want invalid params", test.name, err)
+		}
+	}
+}
+
+func TestValidateSudoParamsNoSchema(t *testing.T) {
+	err := validateSudoParams(schemaTestAction{}, []string{"a"})
+	if !errors.Is(err, ErrInvalidSudoParams) {
+		t.Errorf("params for an empty schema: got %v, want invalid params", err)
+	}
+}
+
+func TestValidateTypedSudoParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		action TypedSudoAction
+		valid  bool
+	}{
+		{"all", schemaTestTypedAction{Path: "/etc/hosts", Count: 3, Force: true, Note: "x"}, true},
+		{"only required", schemaTestTypedAction{Path: "/etc/hosts"}, true},
+		{"pointer", &schemaTestTypedAction{Path: "/etc/hosts"}, true},
+		{"missing required", schemaTestTypedAction{Count: 3}, false},
+		{"relative path", schemaTestTypedAction{Path: "hosts"}, false},
+		{"nil pointer", (*schemaTestTypedAction)(nil), false},
+	}
+
+	for _, test := range tests {
+		err := validateTypedSudoParams(test.action)
+
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.valid && !errors.Is(err, ErrInvalidSudoParams) {
+			t.Errorf("%s: got %v, want invalid params", test.name, err)
+		}
+	}
+
+	// A schema that doesn't match the struct is the action's own bug
+	err := validateTypedSudoParams(schemaTestWrongKindAction{Count: "3"})
+	if err == nil || errors.Is(err, ErrInvalidSudoParams) {
+		t.Errorf("wrong field kind: got %v, want a schema error", err)
+	}
+}
+
+func TestValidateSudoPathUnderTempDir(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := filepath.Join(base, "tmp")
+	outside := filepath.Join(base, "outside")
+
+	for _, dir := range []string{filepath.Join(tempDir, "sub"), outside} {
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = os.Symlink(outside, filepath.Join(tempDir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(tempDir, filepath.Join(base, "tmplink"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTempDir(t, tempDir)
+
+	param := SudoParam{Name: "Path", Type: SudoParamPath, UnderTempDir: true}
+
+	tests := []struct {
+		name  string
+		path  string
+		valid bool
+	}{
+		{"file", filepath.Join(tempDir, "file"), true},
+		{"file in subdirectory", filepath.Join(tempDir, "sub", "file"), true},
+		{"subdirectory", filepath.Join(tempDir, "sub"), true},
+		{"symlink itself", filepath.Join(tempDir, "link"), true},
+		{"through a symlinked temp dir", filepath.Join(base, "tmplink", "file"), true},
+		{"temp dir itself", tempDir, false},
+		{"temp dir with trailing dot", tempDir + "/.", false},
+		{"parent of temp dir", base, false},
+		{"dot dot", tempDir + "/../outside/file", false},
+		{"dot dot in subdirectory", tempDir + "/sub/../../outside/file", false},
+		{"prefix of temp dir", tempDir + "2/file", false},
+		{"through a symlinked parent", filepath.Join(tempDir, "link", "file"), false},
+		{"missing parent", filepath.Join(tempDir, "missing", "file"), false},
+		{"outside", filepath.Join(outside, "file"), false},
+		{"relative", "tmp/file", false},
+	}
+
+	for _, test := range tests {
+		err := validateSudoPath("test.schema", param, test.path)
+
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: %s was accepted", test.name, test.path)
+		}
+	}
+}
+
+func TestValidateSudoPathFile(t *testing.T) {
+	clearInvokingUser(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	link := filepath.Join(dir, "link")
+
+	err := ioutil.WriteFile(file, []byte("x"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Symlink(file, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regularFile := SudoParam{Name: "Path", Type: SudoParamPath, RegularFile: true}
+	directory := SudoParam{Name: "Path", Type: SudoParamPath, Directory: true}
+	owned := SudoParam{Name: "Path", Type: SudoParamPath, OwnedByInvoker: true}
+	unchecked := SudoParam{Name: "Path", Type: SudoParamPath}
+
+	tests := []struct {
+		name  string
+		param SudoParam
+		path  string
+		valid bool
+	}{
+		{"regular file", regularFile, file, true},
+		{"directory as regular file", regularFile, dir, false},
+		{"symlink as regular file", regularFile, link, false},
+		{"missing regular file", regularFile, filepath.Join(dir, "missing"), false},
+		{"directory", directory, dir, true},
+		{"file as directory", directory, file, false},
+		{"missing directory", directory, filepath.Join(dir, "missing"), false},
+		{"owned file", owned, file, true},
+		{"missing owned file", owned, filepath.Join(dir, "missing"), false},
+		{"missing unchecked file", unchecked, filepath.Join(dir, "missing"), true},
+	}
+
+	for _, test := range tests {
+		err := validateSudoPath("test.schema", test.param, test.path)
+
+		if test.valid && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.valid && !errors.Is(err, ErrInvalidSudoParams) {
+			t.Errorf("%s: got %v, want invalid params", test.name, err)
+		}
+	}
+
+	// Files of this process's user aren't the invoking user's once another
+	// user ran it through sudo
+	setInvokingUser(t, os.Getuid()+1000)
+
+	err = validateSudoPath("test.schema", owned, file)
+	if !errors.Is(err, ErrInvalidSudoParams) {
+		t.Errorf("file owned by another user: got %v, want invalid params", err)
+	}
+}
@@ -0,0 +1,22 @@
+package clicommon
+
+// standardActionNamespace is the namespace of the standard actions, so they
+// can't conflict with a program's own actions
+const standardActionNamespace = "clicommon"
+
+// RegisterStandardActions registers the standard file and system actions of
+// this package, like WriteFileSudoAction and ChmodSudoAction, so a program can
+// call them. They aren't registered otherwise, since anyone who may run the
+// program with sudo can run every registered action with params of their
+// choice, and these can write, change and link files anywhere. Programs should
+// only register them if every such user may do that anyway.
+func RegisterStandardActions() {
+	RegisterTypedAction(WriteFileSudoAction{})
+	RegisterTypedAction(MkdirAllSudoAction{})
+	RegisterTypedAction(ChmodSudoAction{})
+	RegisterTypedAction(ChownSudoAction{})
+	RegisterTypedAction(SymlinkSudoAction{})
+	RegisterTypedAction(InstallBinarySudoAction{})
+	RegisterTypedAction(ManagedBlockSudoAction{})
+	RegisterTypedAction(InstallCACertificateSudoAction{})
+}
@@ -4,28 +4,565 @@
 package clicommon
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kardianos/osext"
 	"github.com/mattn/go-isatty"
+	"golang.org/x/sys/unix"
 )
 
-func callSudo(action string, params []string) error {
-	thisExe, err := osext.Executable()
+// How often to check if forwarding stdin should stop, in milliseconds
+const stdinPollInterval = 100
+
+// rootEscalator runs commands directly when this process is already root
+type rootEscalator struct{}
+
+func (rootEscalator) Name() string {
+	return "root"
+}
+
+func (rootEscalator) Available() bool {
+	return os.Geteuid() == 0
+}
+
+func (rootEscalator) SupportsUser() bool {
+	return true
+}
+
+func (rootEscalator) Run(ctx context.Context, request *EscalationRequest) error {
+	if request.User != "" {
+		return runAsUser(ctx, request)
+	}
+
+	return runUnescalated(ctx, request)
+}
+
+func (rootEscalator) alreadyElevated() {}
+
+// commandEscalator runs commands through a setuid helper like sudo, which takes
+// the command to run as its arguments
+type commandEscalator struct {
+	command       string
+	needsTerminal bool
+
+	// nonInteractiveArg makes the command fail instead of asking for a
+	// password, if it has such an option
+	nonInteractiveArg string
+
+	// askpass is set if the command supports SUDO_ASKPASS
+	askpass bool
+
+	// userArg is the option that runs the command as another user, if it
+	// has one
+	userArg string
+
+	// validateArg is the option that only asks for the user's password and
+	// extends how long it's cached, if the command caches it
+	validateArg string
+
+	// passwordRequiredError is part of the error the command prints when it
+	// needs a password but was told not to ask for one
+	passwordRequiredError string
+}
+
+func (e commandEscalator) Name() string {
+	return e.command
+}
+
+func (e commandEscalator) Available() bool {
+	_, err := exec.LookPath(e.command)
+	return err == nil
+}
+
+func (e commandEscalator) SupportsUser() bool {
+	return e.userArg != ""
+}
+
+func (e commandEscalator) Run(ctx context.Context, request *EscalationRequest) error {
+	options := currentEscalationOptions()
+
+	args, escalated, err := e.promptArgs(options, request)
+	if err != nil {
+		return err
+	}
+
+	var userArgs []string
+
+	if request.User != "" {
+		if e.userArg == "" {
+			return fmt.Errorf("%s can't run commands as another user", e.command)
+		}
+
+		userArgs = []string{e.userArg, request.User}
+	}
+
+	args = append(args, userArgs...)
+	args = append(args, request.Executable)
+	args = append(args, request.Args...)
+
+	if options.Prompt != EscalationPromptNever {
+		return runEscalationCommand(ctx, e.command, args, escalated)
+	}
+
+	// The command's exit code can't tell a missing password apart from the
+	// elevated process failing, but its error message can, which comes
+	// first since the elevated process never ran. This doesn't check with
+	// an unrelated command beforehand, since the user may only be allowed
+	// to run this program without a password.
+	stderr := &stderrHead{w: escalated.Stderr}
+	escalated.Stderr = stderr
+
+	err = runEscalationCommand(ctx, e.command, args, escalated)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && e.passwordRequiredError != "" {
+		if line := stderr.firstLine(); strings.Contains(line, e.passwordRequiredError) {
+			return fmt.Errorf("%w (%s)", ErrPasswordRequired, line)
+		}
+	}
+
+	return err
+}
+
+// probe runs the command with its non-interactive option and args, which must
+// not fail by themselves, returning ErrPasswordRequired if it fails
+func (e commandEscalator) probe(ctx context.Context, args []string) error {
+	args = append([]string{e.nonInteractiveArg}, args...)
+
+	err := runEscalationCommand(ctx, e.command, args, &EscalationRequest{})
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%w (%s: %v)", ErrPasswordRequired, e.command, err)
+	}
+
+	return err
+}
+
+// stderrHead passes an escalator's stderr on to w, if it isn't nil, and keeps
+// the start of it, so errors of the escalator itself can be told apart from
+// ones of the elevated process
+type stderrHead struct {
+	w    io.Writer
+	head []byte
+}
+
+// stderrHeadSize is enough for the first line of an escalator's error
+const stderrHeadSize = 512
+
+func (s *stderrHead) Write(p []byte) (int, error) {
+	if n := stderrHeadSize - len(s.head); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+
+		s.head = append(s.head, p[:n]...)
+	}
+
+	if s.w == nil {
+		return len(p), nil
+	}
+
+	return s.w.Write(p)
+}
+
+// firstLine gets the first line that was written, once the command has exited
+func (s *stderrHead) firstLine() string {
+	line := string(s.head)
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+
+	return strings.TrimSpace(line)
+}
+
+// promptArgs gets the arguments that make the command ask for a password the way
+// options say, and the request to run it with, which may need more environment
+// variables for that
+func (e commandEscalator) promptArgs(options EscalationOptions, request *EscalationRequest) ([]string, *EscalationRequest, error) {
+	escalated := *request
+
+	switch options.Prompt {
+	case EscalationPromptNever:
+		if e.nonInteractiveArg == "" {
+			return nil, nil, fmt.Errorf("%s can't run without asking for a password", e.command)
+		}
+
+		return []string{e.nonInteractiveArg}, &escalated, nil
+
+	case EscalationPromptAskpass:
+		if !e.askpass {
+			return nil, nil, fmt.Errorf("%s doesn't support askpass", e.command)
+		}
+
+		thisExe, err := osext.Executable()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		escalated.Env = append(append([]string(nil), request.Env...), "SUDO_ASKPASS="+thisExe, sudoAskpassEnv+"=1")
+
+		return []string{"-A"}, &escalated, nil
+
+	default:
+		if e.needsTerminal && !options.AllowNoTerminal && !isatty.IsTerminal(os.Stdout.Fd()) {
+			return nil, nil, errors.New("not a terminal")
+		}
+
+		return nil, &escalated, nil
+	}
+}
+
+func (e commandEscalator) cachesCredentials() bool {
+	return e.validateArg != ""
+}
+
+// validateCredentials runs the command with its validate option, which asks
+// for the user's password if it isn't cached anymore, unless interactive is
+// unset, and extends how long it's cached otherwise
+func (e commandEscalator) validateCredentials(ctx context.Context, interactive bool) error {
+	options := currentEscalationOptions()
+
+	if !interactive || options.Prompt == EscalationPromptNever {
+		if e.nonInteractiveArg == "" {
+			return fmt.Errorf("%s can't run without asking for a password", e.command)
+		}
+
+		// Validating doesn't run anything, so it can only fail for a
+		// missing password
+		return e.probe(ctx, []string{e.validateArg})
+	}
+
+	args, escalated, err := e.promptArgs(options, &EscalationRequest{Stderr: os.Stderr})
+	if err != nil {
+		return err
+	}
+
+	args = append(args, e.validateArg)
+
+	return runEscalationCommand(ctx, e.command, args, escalated)
+}
+
+func platformEscalators() []Escalator {
+	return []Escalator{
+		rootEscalator{},
+		commandEscalator{command: "sudo", needsTerminal: true, nonInteractiveArg: "-n", askpass: true, userArg: "-u", validateArg: "-v", passwordRequiredError: "a password is required"},
+		commandEscalator{command: "doas", needsTerminal: true, nonInteractiveArg: "-n", userArg: "-u", passwordRequiredError: "Authentication required"},
+		commandEscalator{command: "run0", needsTerminal: true, nonInteractiveArg: "--no-ask-password", userArg: "--user", passwordRequiredError: "Interactive authentication required"},
+		// pkexec can ask for the password through a graphical agent
+		commandEscalator{command: "pkexec", userArg: "--user"},
+	}
+}
+
+// runAsUser runs a command as the request's user when this process is root,
+// setting the same environment variables as sudo so the command knows who
+// requested it
+func runAsUser(ctx context.Context, request *EscalationRequest) error {
+	target, err := user.Lookup(request.User)
+	if err != nil {
+		return err
+	}
+
+	uid, err := strconv.ParseUint(target.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	gid, err := strconv.ParseUint(target.Gid, 10, 32)
 	if err != nil {
 		return err
 	}
 
-	if !isatty.IsTerminal(os.Stdout.Fd()) {
-		return errors.New("not a terminal")
+	groupIDs, err := target.GroupIds()
+	if err != nil {
+		return err
+	}
+
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		if group, err := strconv.ParseUint(groupID, 10, 32); err == nil {
+			groups = append(groups, uint32(group))
+		}
+	}
+
+	cmd := newEscalationCmd(request.Executable, request.Args, request)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{
+			Uid:    uint32(uid),
+			Gid:    uint32(gid),
+			Groups: groups,
+		},
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	cmd.Env = append(cmd.Env,
+		"SUDO_UID="+strconv.Itoa(os.Getuid()),
+		"SUDO_GID="+strconv.Itoa(os.Getgid()),
+		"SUDO_USER="+currentUsername(),
+		"HOME="+target.HomeDir,
+		"USER="+target.Username,
+		"LOGNAME="+target.Username,
+	)
+
+	return runEscalationCmd(ctx, cmd, request)
+}
+
+func sendSudoKey(request *EscalationRequest, handle string, key []byte) error {
+	// Escalators ask for passwords on the terminal itself, so stdin is a
+	// private channel to the elevated process for the payload's key
+	request.Stdin = strings.NewReader(hex.EncodeToString(key) + "\n")
+
+	return nil
+}
+
+// forwardSudoStdin makes the elevated process read from stdin once it has read
+// its key. Reading from stdin only starts once started reports that the
+// elevated process is running, since until then the escalator may be reading
+// the user's password from the same terminal. It stops as soon as the returned
+// function is called after the elevated process exits, so no input meant for
+// this process is taken away from it.
+func forwardSudoStdin(request *EscalationRequest, stdin *os.File, started func() bool) (func(), error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Stdin != nil {
+		_, err = io.Copy(writer, request.Stdin)
+		if err != nil {
+			reader.Close()
+			writer.Close()
+			return nil, err
+		}
+	}
+
+	request.Stdin = reader
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		defer writer.Close()
+
+		for !started() {
+			select {
+			case <-done:
+				return
+			case <-time.After(stdinPollInterval * time.Millisecond):
+			}
+		}
+
+		fds := []unix.PollFd{{Fd: int32(stdin.Fd()), Events: unix.POLLIN}}
+		buf := make([]byte, 4096)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			// Only read once there's input, so reading never blocks past the
+			// elevated process exiting
+			ready, err := unix.Poll(fds, stdinPollInterval)
+			if err == unix.EINTR || (err == nil && ready == 0) {
+				continue
+			} else if err != nil {
+				return
+			}
+
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				_, writeErr := writer.Write(buf[:n])
+				if writeErr != nil {
+					return
+				}
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+
+		// Closing the read end makes any blocked write fail
+		reader.Close()
+		<-stopped
+	}, nil
+}
+
+func readSudoKey(handle string) ([]byte, error) {
+	line, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(line)
+}
+
+// invokingIDs gets the user and group IDs of the user that requested
+// escalation, using the environment variables each escalator sets, or the
+// current user if this process wasn't escalated at all
+func invokingIDs() (uid, gid int) {
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		if gid, err := strconv.Atoi(os.Getenv("SUDO_GID")); err == nil {
+			return uid, gid
+		}
+
+		return uid, primaryGID(user.LookupId(strconv.Itoa(uid)))
+	}
+
+	if uid, err := strconv.Atoi(os.Getenv("PKEXEC_UID")); err == nil {
+		return uid, primaryGID(user.LookupId(strconv.Itoa(uid)))
+	}
+
+	if name := os.Getenv("DOAS_USER"); name != "" {
+		if doasUser, err := user.Lookup(name); err == nil {
+			if uid, err := strconv.Atoi(doasUser.Uid); err == nil {
+				return uid, primaryGID(doasUser, nil)
+			}
+		}
+	}
+
+	return os.Getuid(), os.Getgid()
+}
+
+// startedByEscalator reports if this process was started by sudo, doas, run0 or
+// pkexec, which tell it who ran them
+func startedByEscalator() bool {
+	for _, name := range []string{"SUDO_UID", "PKEXEC_UID", "DOAS_USER"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func primaryGID(u *user.User, err error) int {
+	if err != nil {
+		return -1
+	}
+
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return -1
+	}
+
+	return gid
+}
+
+func invokingUID() int {
+	uid, _ := invokingIDs()
+	return uid
+}
+
+func checkOwnedByInvoker(info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not get the owner of %s", info.Name())
+	}
+
+	if int(stat.Uid) != invokingUID() {
+		return fmt.Errorf("%s is not owned by the invoking user", info.Name())
+	}
+
+	return nil
+}
+
+// checkSudoChannelFile makes sure a file or directory of a sudo channel is owned
+// by the invoking user, and other users can't access it beyond what perm allows.
+// A channel owned by this process's own user is fine too, which is the case when
+// a process that already became root through sudo runs an action directly, so
+// the channel is owned by root while SUDO_UID still names the original user.
+func checkSudoChannelFile(info os.FileInfo, perm os.FileMode) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not get the owner of %s", info.Name())
+	}
+
+	if int(stat.Uid) != os.Geteuid() {
+		err := checkOwnedByInvoker(info)
+		if err != nil {
+			return err
+		}
+	}
+
+	if info.Mode().Perm()&^perm&0077 != 0 {
+		return fmt.Errorf("%s is accessible by other users", info.Name())
+	}
+
+	return nil
+}
+
+// openNoFollow opens a file for reading unless it's a symlink. It doesn't block
+// on FIFOs, which can then be rejected by checking the opened file.
+func openNoFollow(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+}
+
+// sharedTempDir is where channels shared with another user are created, which
+// unlike a per-user TMPDIR every user can enter
+func sharedTempDir() string {
+	return "/tmp"
+}
+
+// fileOwner gets the user and group IDs that own a file
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+
+	return int(stat.Uid), int(stat.Gid)
+}
+
+// runSudoAskpass asks for the password sudo needs, when this program is run as
+// its askpass program, and prints it for sudo to read
+func runSudoAskpass(prompt string) error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	// stdin is shared with the elevated process, which reads its key from it,
+	// and stdout is where sudo reads the password from, so the question has to
+	// go through the terminal instead
+	err = unix.Dup2(int(tty.Fd()), int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	os.Stdout = tty
+
+	password, err := CliQuestionHidden(strings.TrimSuffix(strings.TrimSpace(prompt), ":"))
+	os.Stdout = out
+
+	if err != nil {
+		return err
 	}
 
-	args := append([]string{thisExe, sudoArg, action}, params...)
+	_, err = fmt.Fprintln(out, password)
 
-	cmd := exec.Command("sudo", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return err
 }
@@ -3,8 +3,68 @@
 
 package clicommon
 
-import "errors"
+import (
+	"errors"
+	"os"
+)
 
-func callSudo(action string, params []string) error {
+func platformEscalators() []Escalator {
+	return nil
+}
+
+func sendSudoKey(request *EscalationRequest, handle string, key []byte) error {
 	return errors.New("privilege escalation not supported on this platform")
 }
+
+func forwardSudoStdin(request *EscalationRequest, stdin *os.File, started func() bool) (func(), error) {
+	return nil, errors.New("privilege escalation not supported on this platform")
+}
+
+func readSudoKey(handle string) ([]byte, error) {
+	return nil, errors.New("privilege escalation not supported on this platform")
+}
+
+func invokingIDs() (uid, gid int) {
+	return -1, -1
+}
+
+func startedByEscalator() bool {
+	return false
+}
+
+func checkOwnedByInvoker(info os.FileInfo) error {
+	return nil
+}
+
+func checkSudoChannelFile(info os.FileInfo, perm os.FileMode) error {
+	return nil
+}
+
+// openNoFollow opens a file for reading unless it's a symlink. O_NOFOLLOW isn't
+// available on every platform this builds for, so a symlink swapped in after
+// the check is followed, but actions never run elevated here anyway since
+// there's no escalator.
+func openNoFollow(path string) (*os.File, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("is a symlink")}
+	}
+
+	return os.Open(path)
+}
+
+func sharedTempDir() string {
+	return os.TempDir()
+}
+
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	return -1, -1
+}
+
+func runSudoAskpass(prompt string) error {
+	return errors.New("askpass is not supported on this platform")
+}
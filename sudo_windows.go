@@ -4,32 +4,223 @@
 package clicommon
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"syscall"
+	"unsafe"
 
-	"github.com/kardianos/osext"
 	"golang.org/x/sys/windows"
 )
 
-func callSudo(action string, params []string) error {
-	thisExe, err := osext.Executable()
-	if err != nil {
-		return err
+const (
+	sudoKeyFilename = "key"
+
+	seeMaskNoCloseProcess = 0x00000040
+
+	// How often to check if the request was canceled while waiting for the
+	// elevated process, in milliseconds
+	runasPollInterval = 100
+)
+
+var (
+	shell32             = windows.NewLazySystemDLL("shell32.dll")
+	procShellExecuteExW = shell32.NewProc("ShellExecuteExW")
+)
+
+// shellExecuteInfo is the SHELLEXECUTEINFOW struct, which the x/sys/windows
+// package doesn't provide
+type shellExecuteInfo struct {
+	cbSize       uint32
+	fMask        uint32
+	hwnd         windows.Handle
+	lpVerb       *uint16
+	lpFile       *uint16
+	lpParameters *uint16
+	lpDirectory  *uint16
+	nShow        int32
+	hInstApp     windows.Handle
+	lpIDList     uintptr
+	lpClass      *uint16
+	hkeyClass    windows.Handle
+	dwHotKey     uint32
+	hIcon        windows.Handle
+	hProcess     windows.Handle
+}
+
+// elevatedEscalator runs commands directly when this process is already
+// elevated
+type elevatedEscalator struct{}
+
+func (elevatedEscalator) Name() string {
+	return "elevated"
+}
+
+func (elevatedEscalator) Available() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+func (elevatedEscalator) Run(ctx context.Context, request *EscalationRequest) error {
+	return runUnescalated(ctx, request)
+}
+
+func (elevatedEscalator) alreadyElevated() {}
+
+// runasEscalator runs commands through a UAC prompt. The elevated process gets
+// its own console, so stdin, stdout and stderr of the request are ignored.
+type runasEscalator struct{}
+
+func (runasEscalator) Name() string {
+	return "runas"
+}
+
+func (runasEscalator) Available() bool {
+	return true
+}
+
+func (runasEscalator) detached() {}
+
+func (runasEscalator) Run(ctx context.Context, request *EscalationRequest) error {
+	switch currentEscalationOptions().Prompt {
+	case EscalationPromptNever:
+		// A UAC prompt always needs the user
+		return ErrPasswordRequired
+	case EscalationPromptAskpass:
+		return errors.New("runas doesn't support askpass")
 	}
 
 	// Inspired by https://stackoverflow.com/a/59147866/3052732
 	verb := "runas"
 	cwd, _ := os.Getwd()
-	args := append([]string{sudoArg, action}, params...)
 
 	verbPtr, _ := syscall.UTF16PtrFromString(verb)
-	exePtr, _ := syscall.UTF16PtrFromString(thisExe)
+	exePtr, _ := syscall.UTF16PtrFromString(request.Executable)
 	cwdPtr, _ := syscall.UTF16PtrFromString(cwd)
-	argPtr, _ := syscall.UTF16PtrFromString(makeCmdLine(args))
+	argPtr, _ := syscall.UTF16PtrFromString(makeCmdLine(request.Args))
+
+	// ShellExecuteEx instead of ShellExecute so we get a handle to wait on the
+	// elevated process and read its response afterwards
+	info := shellExecuteInfo{
+		fMask:        seeMaskNoCloseProcess,
+		lpVerb:       verbPtr,
+		lpFile:       exePtr,
+		lpParameters: argPtr,
+		lpDirectory:  cwdPtr,
+		nShow:        windows.SW_NORMAL,
+	}
+	info.cbSize = uint32(unsafe.Sizeof(info))
+
+	ok, _, err := procShellExecuteExW.Call(uintptr(unsafe.Pointer(&info)))
+	if ok == 0 {
+		return err
+	}
+
+	defer windows.CloseHandle(info.hProcess)
+
+	for {
+		event, err := windows.WaitForSingleObject(info.hProcess, runasPollInterval)
+		if err != nil {
+			return err
+		}
+
+		if event != uint32(windows.WAIT_TIMEOUT) {
+			break
+		}
+
+		if ctx.Err() != nil {
+			windows.TerminateProcess(info.hProcess, 1)
+			return ctx.Err()
+		}
+	}
+
+	var exitCode uint32
+
+	err = windows.GetExitCodeProcess(info.hProcess, &exitCode)
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return &exitCodeError{code: int(exitCode)}
+	}
+
+	return nil
+}
+
+func platformEscalators() []Escalator {
+	return []Escalator{
+		elevatedEscalator{},
+		runasEscalator{},
+	}
+}
 
-	var showCmd int32 = 1 // SW_NORMAL
+func sendSudoKey(request *EscalationRequest, handle string, key []byte) error {
+	// A UAC prompt can't give the elevated process a stdin, so the key goes
+	// next to the payload, in the directory only this user can read
+	return writeNewFile(filepath.Join(handle, sudoKeyFilename), []byte(hex.EncodeToString(key)), sudoPayloadPermissions)
+}
+
+func forwardSudoStdin(request *EscalationRequest, stdin *os.File, started func() bool) (func(), error) {
+	// The key isn't sent through stdin, so it can be passed on as it is
+	request.Stdin = stdin
+
+	return func() {}, nil
+}
+
+func readSudoKey(handle string) ([]byte, error) {
+	filename := filepath.Join(handle, sudoKeyFilename)
+	defer os.Remove(filename)
+
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(string(text))
+}
 
-	return windows.ShellExecute(0, verbPtr, exePtr, argPtr, cwdPtr, showCmd)
+func invokingIDs() (uid, gid int) {
+	return -1, -1
+}
+
+// startedByEscalator is always false, since a UAC prompt always starts an
+// elevated process, and asks the user every time
+func startedByEscalator() bool {
+	return false
+}
+
+func checkOwnedByInvoker(info os.FileInfo) error {
+	return nil
+}
+
+func checkSudoChannelFile(info os.FileInfo, perm os.FileMode) error {
+	return nil
+}
+
+// openNoFollow opens a file for reading unless it's a symlink. There's no way to
+// do that atomically here, but only administrators can create symlinks anyway.
+func openNoFollow(path string) (*os.File, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("is a symlink")}
+	}
+
+	return os.Open(path)
+}
+
+func sharedTempDir() string {
+	return os.TempDir()
+}
+
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	return -1, -1
 }
 
 // Copy of builtin Go algorithm: https://github.com/golang/go/blob/75032ad8cfac4aefbacd17b47346ac8c1b5ff33f/src/syscall/exec_windows.go#L44
@@ -104,3 +295,7 @@ func makeCmdLine(args []string) string {
 	}
 	return string(b)
 }
+
+func runSudoAskpass(prompt string) error {
+	return errors.New("askpass is not supported on this platform")
+}
@@ -27,6 +27,9 @@ const (
 
 	binaryFilePermissions = 0755
 
+	// A hex-encoded ed25519 signature with some room for whitespace
+	maxSignatureSize = 1024
+
 	githubLatestReleaseTemplate = "https://api.github.com/repos/%s/releases/latest"
 	githubReleaseAssetTemplate  = "https://api.github.com/repos/%s/releases/assets/%d"
 )
@@ -51,6 +54,10 @@ type updatedRelease struct {
 	version     string
 	downloadURL string
 	githubToken string
+
+	// signatureURL is the release's signature of the executable, an asset
+	// named like the archive with a .sig suffix, if it has one
+	signatureURL string
 }
 
 func NewAutoUpdater(
@@ -101,6 +108,8 @@ func NewAutoUpdater(
 				githubToken = netrcToken
 			}
 		}
+
+		RegisterSecret(githubToken)
 	}
 
 	updater := &AutoUpdater{
@@ -139,7 +148,7 @@ func (updater *AutoUpdater) TryAutoUpdateSelf() error {
 	if update != nil && updater.buildVersion != "dev" {
 		err = update.apply(true)
 		if err != nil {
-			fmt.Println("Error performing self-update:", err)
+			Log.Error("Error performing self-update", "error", err)
 		}
 	}
 
@@ -158,7 +167,7 @@ func (updater *AutoUpdater) TryManualUpdate() error {
 	if update != nil {
 		err = update.apply(false)
 		if err != nil {
-			fmt.Println("Error performing self-update:", err)
+			Log.Error("Error performing self-update", "error", err)
 		}
 	} else {
 		fmt.Println("No updates found")
@@ -220,6 +229,8 @@ func (updater *AutoUpdater) getOrAskForToken(forceNew bool) (string, error) {
 		return "", err
 	}
 
+	RegisterSecret(newToken)
+
 	updater.config.GithubToken = newToken
 	updater.githubToken = newToken
 
@@ -246,6 +257,7 @@ func (updater *AutoUpdater) checkForUpdate(force bool) (*updatedRelease, error)
 
 	if !force {
 		if updater.config.LastUpdateTime != nil && *updater.config.LastUpdateTime > now-24*60*60 {
+			Log.Debug("Skipping update check", "lastUpdateTime", *updater.config.LastUpdateTime)
 			return nil, nil
 		}
 	}
@@ -318,6 +330,8 @@ func (updater *AutoUpdater) checkForUpdate(force bool) (*updatedRelease, error)
 		remoteVersion := normalizeVersion(releaseData.TagName)
 		localVersion := normalizeVersion(updater.buildVersion)
 
+		Log.Debug("Found latest release", "repo", updater.githubRepo, "remoteVersion", remoteVersion, "localVersion", localVersion)
+
 		if remoteVersion != localVersion {
 			break
 		}
@@ -332,9 +346,19 @@ func (updater *AutoUpdater) checkForUpdate(force bool) (*updatedRelease, error)
 
 	expectedSuffix := runtime.GOOS + "_" + runtime.GOARCH + ".tar.gz"
 
+	var archiveName string
+
 	for _, asset := range releaseData.Assets {
 		if strings.HasSuffix(asset.Name, expectedSuffix) {
 			update.downloadURL = fmt.Sprintf(githubReleaseAssetTemplate, updater.githubRepo, asset.ID)
+			archiveName = asset.Name
+			break
+		}
+	}
+
+	for _, asset := range releaseData.Assets {
+		if archiveName != "" && asset.Name == archiveName+".sig" {
+			update.signatureURL = fmt.Sprintf(githubReleaseAssetTemplate, updater.githubRepo, asset.ID)
 			break
 		}
 	}
@@ -350,27 +374,39 @@ func (update *updatedRelease) apply(restart bool) error {
 		return err
 	}
 
-	req, err := http.NewRequest("GET", update.downloadURL, nil)
+	parentDir := filepath.Dir(thisExe)
+	needsSudo := false
+	tmpDir := parentDir
+
+	file, err := ioutil.TempFile(tmpDir, filepath.Base(thisExe))
 	if err != nil {
-		return err
-	}
+		needsSudo = true
+		tmpDir = os.TempDir()
 
-	if update.githubToken != "" {
-		req.Header.Set("Authorization", "Bearer "+update.githubToken)
+		// Checked before downloading anything, since the update couldn't be
+		// installed anyway
+		err = update.checkSignable()
+		if err != nil {
+			return err
+		}
+
+		file, err = ioutil.TempFile(tmpDir, filepath.Base(thisExe))
+		if err != nil {
+			return err
+		}
 	}
 
-	req.Header.Set("Accept", "application/octet-stream")
-	resp, err := http.DefaultClient.Do(req)
+	// Does nothing once the file has been moved into place
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	resp, err := update.download(update.downloadURL)
 	if err != nil {
 		return err
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return errors.New("did not get 200 status code from update download")
-	}
-
 	gzipReader, _ := gzip.NewReader(resp.Body)
 	defer gzipReader.Close()
 
@@ -387,23 +423,6 @@ func (update *updatedRelease) apply(restart bool) error {
 		}
 	}
 
-	parentDir := filepath.Dir(thisExe)
-	needsSudo := false
-	tmpDir := parentDir
-
-	file, err := ioutil.TempFile(tmpDir, filepath.Base(thisExe))
-	if err != nil {
-		needsSudo = true
-		tmpDir = os.TempDir()
-
-		file, err = ioutil.TempFile(tmpDir, filepath.Base(thisExe))
-		if err != nil {
-			return err
-		}
-	}
-
-	defer file.Close()
-
 	_, err = io.Copy(file, tarReader)
 	if err != nil {
 		return err
@@ -417,7 +436,16 @@ func (update *updatedRelease) apply(restart bool) error {
 	file.Close()
 
 	if needsSudo {
-		err = CallSudo(ReplaceExecutableSudoAction{NewExe: file.Name()})
+		signature, err := update.downloadSignature()
+		if err != nil {
+			return err
+		}
+
+		err = CallSudo(ReplaceExecutableSudoAction{NewExe: file.Name(), Signature: signature})
+
+		// Removed here too, since restarting doesn't run deferred calls
+		os.Remove(file.Name())
+
 		if err != nil {
 			return err
 		}
@@ -444,6 +472,77 @@ func (update *updatedRelease) apply(restart bool) error {
 	return nil
 }
 
+// download gets a release asset
+func (update *updatedRelease) download(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.githubToken != "" {
+		req.Header.Set("Authorization", "Bearer "+update.githubToken)
+	}
+
+	req.Header.Set("Accept", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, errors.New("did not get 200 status code from update download")
+	}
+
+	return resp, nil
+}
+
+// checkSignable makes sure the release can be installed with superuser
+// permissions, which needs a signing key and a signature unless unsigned
+// executables are allowed
+func (update *updatedRelease) checkSignable() error {
+	key, unsigned := currentExecutableSigningKey()
+
+	switch {
+	case unsigned:
+		return nil
+	case key == nil:
+		return fmt.Errorf("updating needs superuser permissions: %w", errExecutableSigningKeyMissing)
+	case update.signatureURL == "":
+		return errors.New("updating needs superuser permissions, but the release has no signature")
+	}
+
+	return nil
+}
+
+// downloadSignature gets the hex-encoded signature of the release's executable,
+// which ReplaceExecutableSudoAction needs to install it, or nothing if it's
+// installed unsigned
+func (update *updatedRelease) downloadSignature() (string, error) {
+	if _, unsigned := currentExecutableSigningKey(); unsigned && update.signatureURL == "" {
+		return "", nil
+	}
+
+	err := update.checkSignable()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := update.download(update.signatureURL)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	text, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(text)), nil
+}
+
 func tryFindGithubToken() string {
 	// TODO: are there any other easy ways to get preexisting GitHub tokens?
 
@@ -19,13 +19,15 @@ func NewUserConfigDir(name string) *UserConfigDir {
 	}
 }
 
-// GetConfigDir gets the os-dependent user configuration directory
+// GetConfigDir gets the os-dependent user configuration directory. In a sudo
+// action it's the directory of the user that requested the action, and files
+// saved there are owned by that user.
 func (userConfigDir *UserConfigDir) GetConfigDir() (string, error) {
 	var dir string
 
 	switch runtime.GOOS {
 	case "linux", "darwin":
-		home, err := os.UserHomeDir()
+		home, err := userHomeDir()
 		if err != nil {
 			return "", err
 		}
@@ -33,7 +35,7 @@ func (userConfigDir *UserConfigDir) GetConfigDir() (string, error) {
 		dir = fmt.Sprintf("%s/.config/%s/", home, userConfigDir.name)
 
 	case "windows":
-		home, err := os.UserHomeDir()
+		home, err := userHomeDir()
 		if err != nil {
 			return "", err
 		}
@@ -41,14 +43,40 @@ func (userConfigDir *UserConfigDir) GetConfigDir() (string, error) {
 		dir = fmt.Sprintf("%s\\AppData\\Roaming\\%s\\", home, userConfigDir.name)
 	}
 
-	info, err := os.Stat(dir)
+	err := ensureDir(dir, "config")
 	if err != nil {
-		err = os.MkdirAll(dir, 0770)
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// GetStateDir gets the os-dependent user state directory, meant for files like
+// logs that aren't configuration but should still persist between runs
+func (userConfigDir *UserConfigDir) GetStateDir() (string, error) {
+	var dir string
+
+	switch runtime.GOOS {
+	case "linux", "darwin":
+		home, err := userHomeDir()
 		if err != nil {
 			return "", err
 		}
-	} else if !info.IsDir() {
-		return "", errors.New("config path is not a directory")
+
+		dir = fmt.Sprintf("%s/.local/state/%s/", home, userConfigDir.name)
+
+	case "windows":
+		home, err := userHomeDir()
+		if err != nil {
+			return "", err
+		}
+
+		dir = fmt.Sprintf("%s\\AppData\\Local\\%s\\", home, userConfigDir.name)
+	}
+
+	err := ensureDir(dir, "state")
+	if err != nil {
+		return "", err
 	}
 
 	return dir, nil
@@ -90,7 +118,7 @@ func (userConfigDir *UserConfigDir) SaveConfig(config string, data interface{})
 		return err
 	}
 
-	err = ioutil.WriteFile(filename, text, 0660)
+	err = WriteFileAsInvokingUser(filename, text, 0660)
 	if err != nil {
 		return err
 	}
@@ -128,3 +156,30 @@ func SaveExternalConfig(filename string, data interface{}) error {
 
 	return nil
 }
+
+// userHomeDir gets the home directory of the user, which in a sudo action is
+// the user that requested it rather than root, so their config is used
+func userHomeDir() (string, error) {
+	if !isElevatedForInvoker() {
+		return os.UserHomeDir()
+	}
+
+	invoker, err := LookupInvokingUser()
+	if err != nil {
+		return "", err
+	}
+
+	return invoker.HomeDir, nil
+}
+
+// ensureDir creates a config or state directory if it doesn't exist yet
+func ensureDir(dir, kind string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return MkdirAllAsInvokingUser(dir, 0770)
+	} else if !info.IsDir() {
+		return errors.New(kind + " path is not a directory")
+	}
+
+	return nil
+}